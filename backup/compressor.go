@@ -8,11 +8,86 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+)
+
+// 圧縮方式
+const (
+	CompressionGzip  = "gzip"  // 標準ライブラリのgzip (シングルスレッド)
+	CompressionPgzip = "pgzip" // ブロック単位で並列圧縮するgzip (出力は通常のgzip)
+	CompressionZstd  = "zstd"  // マルチワーカーのzstd
 )
 
+// CompressionOptions はアーカイブ作成時の圧縮設定です。
+type CompressionOptions struct {
+	Method    string // gzip, pgzip, zstd のいずれか
+	Workers   int    // 並列圧縮で使うワーカー数 (pgzip, zstd のみ)
+	BlockSize int    // pgzip の1ブロックあたりのサイズ (バイト)
+}
+
+// archiveExtension は圧縮方式に対応するアーカイブの拡張子を返します。
+func archiveExtension(method string) string {
+	if method == CompressionZstd {
+		return ".tar.zst"
+	}
+	return ".tar.gz"
+}
+
+// backupS3Prefix はバックアップをアップロードするS3キーの接頭辞です。
+// バックアップごとに日時付きのキー (<BACKUP_FILE_NAME_PREFIX>_<日時>.tar.gz など) にアップロードし、
+// latest_world にコピーします。
+const backupS3Prefix = "minecraft_backups/"
+
+// latestBackupPrefix は最新のバックアップのS3キーから拡張子を除いた部分です。
+const latestBackupPrefix = backupS3Prefix + "latest_world"
 
-func createTarGz(sourceDirs []string, outputFile string) error {
-	log.Printf("'%s' を tar.gz 形式で '%s' に圧縮します...", sourceDirs, outputFile)
+// latestBackupKey は最新のバックアップをアップロードするS3のキーを返します。
+// 拡張子は圧縮方式で変わる (zstd の場合は latest_world.tar.zst) ため、固定のキーを読む側は圧縮方式に合わせてください。
+// spot-handler には latest_backup.json で、上書きされない日時付きのキーを渡します。
+func latestBackupKey(method string) string {
+	return latestBackupPrefix + archiveExtension(method)
+}
+
+// newCompressWriter は圧縮方式に応じたライターを作成します。
+func newCompressWriter(w io.Writer, opts CompressionOptions) (io.WriteCloser, error) {
+	switch opts.Method {
+	case CompressionGzip, "":
+		return gzip.NewWriter(w), nil
+	case CompressionPgzip:
+		pw := pgzip.NewWriter(w)
+		if err := pw.SetConcurrency(opts.BlockSize, opts.Workers); err != nil {
+			return nil, fmt.Errorf("pgzipの並列設定に失敗しました: %w", err)
+		}
+		return pw, nil
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(opts.Workers))
+		if err != nil {
+			return nil, fmt.Errorf("zstdライターの作成に失敗しました: %w", err)
+		}
+		return zw, nil
+	default:
+		return nil, fmt.Errorf("未対応の圧縮方式です: %s", opts.Method)
+	}
+}
+
+// countingWriter は書き込まれたバイト数を数えます。スループットの計測に使います。
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func createArchive(sourceDirs []string, outputFile string, opts CompressionOptions) error {
+	log.Printf("'%s' を %s (ワーカー数: %d) で '%s' に圧縮します...", sourceDirs, opts.Method, opts.Workers, outputFile)
+	start := time.Now()
 
 	// 出力ファイルのディレクトリが存在することを確認し、なければ作成
 	outputDir := filepath.Dir(outputFile)
@@ -25,18 +100,43 @@ func createTarGz(sourceDirs []string, outputFile string) error {
 	if err != nil {
 		return fmt.Errorf("出力ファイル '%s' の作成に失敗しました: %w", outputFile, err)
 	}
-	defer file.Close()
 
-	// gzipライターを作成
-	gw := gzip.NewWriter(file)
-	defer gw.Close()
+	// 圧縮後のサイズを数えるライター
+	compressed := &countingWriter{w: file}
+
+	// 圧縮ライターを作成
+	cw, err := newCompressWriter(compressed, opts)
+	if err != nil {
+		file.Close()
+		removePartialArchive(outputFile)
+		return err
+	}
+
+	// 圧縮前のサイズを数えるライター
+	raw := &countingWriter{w: cw}
 
 	// tarライターを作成
-	tw := tar.NewWriter(gw)
-	defer tw.Close()
+	tw := tar.NewWriter(raw)
+
+	err = writeTar(tw, sourceDirs)
+	// 成功しても失敗しても、tar → 圧縮 → ファイルの順にここで一度だけ閉じる
+	if closeErr := closeArchive(tw, cw, file, outputFile); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		removePartialArchive(outputFile)
+		return err
+	}
 
+	logThroughput(opts.Method, raw.n, compressed.n, time.Since(start))
+	log.Printf("すべてのディレクトリの圧縮が完了しました。")
+	return nil
+}
+
+// writeTar はソースディレクトリの内容をtarアーカイブに書き込みます。
+func writeTar(tw *tar.Writer, sourceDirs []string) error {
 	for _, sourceDir := range sourceDirs {
-		srcInfo , err := os.Stat(sourceDir)
+		srcInfo, err := os.Stat(sourceDir)
 		if os.IsNotExist(err) {
 			return fmt.Errorf("ソースディレクトリが存在しません: %s", sourceDir)
 		}
@@ -91,10 +191,54 @@ func createTarGz(sourceDirs []string, outputFile string) error {
 		})
 
 		if err != nil {
-			return fmt.Errorf("ディレクトリ '%s' のtar.gz作成中にエラーが発生しました: %w", sourceDir, err)
+			return fmt.Errorf("ディレクトリ '%s' のアーカイブ作成中にエラーが発生しました: %w", sourceDir, err)
 		}
 	}
 
-	log.Printf("すべてのディレクトリの圧縮が完了しました。")
 	return nil
 }
+
+// closeArchive はtar、圧縮ストリーム、出力ファイルを順に閉じ、最初のエラーを返します。
+// 前のものが失敗しても、ファイルディスクリプタを残さないよう後ろのものは閉じます。
+func closeArchive(tw *tar.Writer, cw io.Closer, file *os.File, outputFile string) error {
+	var first error
+	if err := tw.Close(); err != nil {
+		first = fmt.Errorf("tarアーカイブの終了処理に失敗しました: %w", err)
+	}
+	if err := cw.Close(); err != nil && first == nil {
+		first = fmt.Errorf("圧縮ストリームの終了処理に失敗しました: %w", err)
+	}
+	if err := file.Close(); err != nil && first == nil {
+		first = fmt.Errorf("出力ファイル '%s' のクローズに失敗しました: %w", outputFile, err)
+	}
+	return first
+}
+
+// removePartialArchive は途中まで書き込んだアーカイブを削除します。
+// 壊れたアーカイブが残ると、次の処理や手作業で正常なバックアップとして扱われるおそれがあるためです。
+func removePartialArchive(outputFile string) {
+	if err := os.Remove(outputFile); err != nil && !os.IsNotExist(err) {
+		log.Printf("警告: 作成途中のアーカイブ '%s' の削除に失敗しました: %v", outputFile, err)
+	}
+}
+
+// logThroughput は圧縮のスループットと圧縮率をログに出力します。
+func logThroughput(method string, rawBytes, compressedBytes int64, elapsed time.Duration) {
+	const mib = 1024 * 1024
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		seconds = 1e-9
+	}
+	ratio := 0.0
+	if rawBytes > 0 {
+		ratio = float64(compressedBytes) / float64(rawBytes) * 100
+	}
+	log.Printf("圧縮統計 [%s]: 元サイズ %.1f MiB -> 圧縮後 %.1f MiB (%.1f%%), 所要時間 %s, スループット %.1f MiB/s",
+		method,
+		float64(rawBytes)/mib,
+		float64(compressedBytes)/mib,
+		ratio,
+		elapsed.Round(time.Millisecond),
+		float64(rawBytes)/mib/seconds,
+	)
+}
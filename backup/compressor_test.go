@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateArchiveRemovesPartialOutput(t *testing.T) {
+	dir := t.TempDir()
+	world := filepath.Join(dir, "world")
+	if err := os.MkdirAll(world, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(world, "level.dat"), []byte("level"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "out", "world.tar.gz")
+
+	// 2つ目のディレクトリがないため、1つ目を書き込んだ後に失敗する
+	err := createArchive([]string{world, filepath.Join(dir, "missing")}, output, CompressionOptions{Method: CompressionGzip, Workers: 1, BlockSize: 1 << 20})
+	if err == nil {
+		t.Fatal("createArchive = nil, want an error for the missing directory")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("partial archive was left behind: stat err = %v", err)
+	}
+}
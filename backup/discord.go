@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// discordEmbed はDiscord Webhookで送信する埋め込みメッセージです。
+type discordEmbed struct {
+	Title       string              `json:"title"`
+	Description string              `json:"description,omitempty"`
+	URL         string              `json:"url,omitempty"`
+	Color       int                 `json:"color"`
+	Fields      []discordEmbedField `json:"fields,omitempty"`
+	Footer      *discordEmbedFooter `json:"footer,omitempty"`
+	Timestamp   string              `json:"timestamp,omitempty"`
+}
+
+type discordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordEmbedFooter struct {
+	Text string `json:"text"`
+}
+
+type discordWebhookPayload struct {
+	Username  string         `json:"username"`
+	AvatarURL string         `json:"avatar_url,omitempty"`
+	Embeds    []discordEmbed `json:"embeds"`
+}
+
+// postDiscordEmbed はDiscordのWebhookに埋め込みメッセージを送信します。
+func postDiscordEmbed(ctx context.Context, webhookURL string, embed discordEmbed) error {
+	if embed.Footer == nil {
+		embed.Footer = &discordEmbedFooter{Text: "Automated notification from minecraft backup tool"}
+	}
+	if embed.Timestamp == "" {
+		embed.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	}
+	payload := discordWebhookPayload{
+		Username:  "Minecraft Server Bot",
+		AvatarURL: "https://i.imgur.com/v1hGfV8.png",
+		Embeds:    []discordEmbed{embed},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("Discord通知のJSON作成に失敗しました: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Discord通知のリクエスト作成に失敗しました: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Discord通知の送信に失敗しました: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Discord通知の送信に失敗しました (status %d)", resp.StatusCode)
+	}
+	log.Println("Discordに通知を送信しました。")
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultLevelName         = "world"
+	serverPropertiesFileName = "server.properties"
+	// Multiverse-Coreが管理するワールド一覧
+	multiverseWorldsFile = "plugins/Multiverse-Core/worlds.yml"
+)
+
+// discoverWorldDirs はサーバーのルートディレクトリからバックアップ対象のワールドディレクトリを探します。
+// server.properties の level-name をもとに Bukkit/Paper のディメンションごとのフォルダを探し、
+// Multiverse の worlds.yml に登録されている追加ワールドも含めます。
+func discoverWorldDirs(serverDir string) ([]string, error) {
+	info, err := os.Stat(serverDir)
+	if err != nil {
+		return nil, fmt.Errorf("サーバーディレクトリ '%s' を確認できませんでした: %w", serverDir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("サーバーディレクトリ '%s' はディレクトリではありません", serverDir)
+	}
+
+	levelName, err := readLevelName(serverDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bukkit/Paperはディメンションごとに別フォルダへ保存する
+	candidates := []string{levelName, levelName + "_nether", levelName + "_the_end"}
+
+	mvWorlds, err := readMultiverseWorlds(serverDir)
+	if err != nil {
+		return nil, err
+	}
+	candidates = append(candidates, mvWorlds...)
+
+	var worldDirs []string
+	seen := make(map[string]bool)
+	for _, name := range candidates {
+		dir := filepath.Join(serverDir, name)
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		if !isWorldDir(dir) {
+			log.Printf("情報: ワールドディレクトリ '%s' が見つからないためスキップします。", dir)
+			continue
+		}
+		worldDirs = append(worldDirs, dir)
+	}
+
+	if len(worldDirs) == 0 {
+		return nil, fmt.Errorf("サーバーディレクトリ '%s' にワールドが見つかりませんでした (level-name: %s)", serverDir, levelName)
+	}
+	return worldDirs, nil
+}
+
+// readLevelName は server.properties から level-name を読み取ります。
+// ファイルやキーがない場合はMinecraftのデフォルト値 'world' を返します。
+func readLevelName(serverDir string) (string, error) {
+	props, err := readServerProperties(filepath.Join(serverDir, serverPropertiesFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Printf("警告: %s が見つかりません。level-name はデフォルト値 '%s' とみなします。", serverPropertiesFileName, defaultLevelName)
+			return defaultLevelName, nil
+		}
+		return "", err
+	}
+	levelName := props["level-name"]
+	if levelName == "" {
+		return defaultLevelName, nil
+	}
+	return levelName, nil
+}
+
+// readServerProperties は Java の .properties 形式のファイルを読み込みます。
+// server.properties で使われる範囲 (コメント、key=value、エスケープ) のみに対応しています。
+func readServerProperties(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	props := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			key, value, _ = strings.Cut(line, ":")
+		}
+		props[strings.TrimSpace(key)] = unescapeProperty(strings.TrimSpace(value))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("'%s' の読み込みに失敗しました: %w", path, err)
+	}
+	return props, nil
+}
+
+// unescapeProperty は .properties の値に含まれるバックスラッシュエスケープを解除します。
+func unescapeProperty(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	escaped := false
+	for _, r := range s {
+		if escaped {
+			switch r {
+			case 't':
+				b.WriteRune('\t')
+			case 'n':
+				b.WriteRune('\n')
+			case 'r':
+				b.WriteRune('\r')
+			default:
+				b.WriteRune(r)
+			}
+			escaped = false
+			continue
+		}
+		if r == '\\' {
+			escaped = true
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// readMultiverseWorlds は Multiverse-Core の worlds.yml に登録されたワールド名を返します。
+// Multiverse 4 は "worlds:" の下に、Multiverse 5 はトップレベルにワールド名を並べます。
+func readMultiverseWorlds(serverDir string) ([]string, error) {
+	path := filepath.Join(serverDir, multiverseWorldsFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("'%s' の読み込みに失敗しました: %w", path, err)
+	}
+
+	var doc map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("'%s' のパースに失敗しました: %w", path, err)
+	}
+
+	var worlds map[string]yaml.Node
+	if node, ok := doc["worlds"]; ok && node.Kind == yaml.MappingNode {
+		if err := node.Decode(&worlds); err != nil {
+			return nil, fmt.Errorf("'%s' のワールド一覧の読み込みに失敗しました: %w", path, err)
+		}
+	} else {
+		worlds = doc
+	}
+
+	names := make([]string, 0, len(worlds))
+	for name := range worlds {
+		// MV5はバージョン情報などのメタデータもトップレベルに置く
+		if strings.HasPrefix(name, "=") || name == "version" {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// isWorldDir はディレクトリがMinecraftのワールドらしいか (level.dat を含むか) を判定します。
+func isWorldDir(dir string) bool {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(dir, "level.dat"))
+	return err == nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runExport はサーバーのワールドをバニラのシングルプレイ用セーブデータとしてzipに書き出します。
+//
+//	backup export [-backup <アーカイブ または s3://bucket/key>] [-name <ワールド名>] [-output <zipファイル>]
+//
+// -backup を省略した場合は稼働中のワールドディレクトリから書き出します。
+// バックアップに複数のワールドがあり server.properties も含まれていない場合は、-name で書き出すワールドを指定します。
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	backupPath := fs.String("backup", "", "書き出し元のバックアップアーカイブ (.tar.gz / .tar.zst または s3://bucket/key)。省略時は稼働中のワールドを使用")
+	worldName := fs.String("name", "", "シングルプレイでのワールドフォルダ名 (省略時はオーバーワールドのフォルダ名)。バックアップに複数のワールドがある場合は書き出すワールドのフォルダ名も兼ねる")
+	output := fs.String("output", "", "出力するzipファイルのパス (省略時は BACKUP_OUTPUT_PATH 配下)")
+	fs.Parse(args)
+
+	ctx := context.Background()
+
+	workDir, err := os.MkdirTemp("", "minecraft-export-")
+	if err != nil {
+		return fmt.Errorf("作業ディレクトリの作成に失敗しました: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	// 書き出し元のワールドディレクトリを決定する
+	var sourceDirs []string
+	levelName := ""
+	if *backupPath != "" {
+		sourceDir := filepath.Join(workDir, "source")
+		sourceDirs, err = unpackBackup(ctx, *backupPath, sourceDir)
+		if err == nil {
+			levelName, err = backupLevelName(sourceDir, sourceDirs, *worldName)
+		}
+	} else {
+		sourceDirs, err = loadWorldDirs()
+		if serverDir := os.Getenv("MINECRAFT_SERVER_DIR"); serverDir != "" && err == nil {
+			levelName, err = readLevelName(serverDir)
+		}
+		log.Println("警告: 稼働中のワールドから書き出します。サーバー停止中か save-off 状態で実行しないとデータが不整合になる可能性があります。")
+	}
+	if err != nil {
+		return err
+	}
+
+	world, err := findServerWorld(sourceDirs, levelName)
+	if err != nil {
+		return err
+	}
+
+	name := *worldName
+	if name == "" {
+		name = filepath.Base(world.Overworld)
+	}
+
+	// バニラのレイアウトに組み直す
+	vanillaDir := filepath.Join(workDir, "vanilla")
+	if err := buildVanillaLayout(world, vanillaDir); err != nil {
+		return err
+	}
+
+	zipPath := *output
+	if zipPath == "" {
+		outputDir := os.Getenv("BACKUP_OUTPUT_PATH")
+		if outputDir == "" {
+			outputDir = "."
+		}
+		zipPath = filepath.Join(outputDir, fmt.Sprintf("%s_singleplayer_%s.zip", name, time.Now().Format("20060102_150405")))
+	}
+
+	log.Printf("シングルプレイ用ワールド '%s' を '%s' に書き出しています...", name, zipPath)
+	if err := writeZip(vanillaDir, zipPath, name); err != nil {
+		return err
+	}
+	log.Printf("エクスポートが完了しました: %s", zipPath)
+	return nil
+}
+
+// backupLevelName はバックアップから書き出すオーバーワールドの名前を返します。
+// バックアップに server.properties が含まれていればその level-name を使い、
+// なければ -name と同じ名前のワールドを使います。どちらもない場合は空文字列を返し、findServerWorld に任せます。
+func backupLevelName(sourceDir string, sourceDirs []string, worldName string) (string, error) {
+	if _, err := os.Stat(filepath.Join(sourceDir, serverPropertiesFileName)); err == nil {
+		return readLevelName(sourceDir)
+	}
+	for _, dir := range sourceDirs {
+		if worldName != "" && filepath.Base(dir) == worldName {
+			return worldName, nil
+		}
+	}
+	return "", nil
+}
+
+// unpackBackup はバックアップアーカイブを destDir に展開し、含まれていたワールドディレクトリの一覧を返します。
+// archive が s3:// で始まる場合はS3からダウンロードしてから展開します。
+func unpackBackup(ctx context.Context, archive, destDir string) ([]string, error) {
+	localPath := archive
+	if strings.HasPrefix(archive, "s3://") {
+		bucket, key, err := parseS3URI(archive)
+		if err != nil {
+			return nil, err
+		}
+		localPath = filepath.Join(filepath.Dir(destDir), filepath.Base(key))
+		if err := downloadFromS3(ctx, bucket, key, localPath, os.Getenv("AWS_REGION")); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("バックアップ '%s' を展開しています...", localPath)
+	if err := extractArchive(localPath, destDir); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(destDir)
+	if err != nil {
+		return nil, fmt.Errorf("展開先ディレクトリ '%s' の読み込みに失敗しました: %w", destDir, err)
+	}
+	var dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, filepath.Join(destDir, entry.Name()))
+		}
+	}
+	return dirs, nil
+}
+
+// buildVanillaLayout はBukkit/Paperのワールドをバニラのフォルダ構成で destDir に組み立てます。
+// Nether と End はそれぞれ DIM-1、DIM1 としてオーバーワールドのフォルダ内に配置します。
+func buildVanillaLayout(world *serverWorld, destDir string) error {
+	skipPaperFiles := func(relPath string, info os.FileInfo) bool {
+		return !info.IsDir() && paperOnlyFiles[filepath.Base(relPath)]
+	}
+
+	log.Printf("オーバーワールド '%s' をコピーしています...", world.Overworld)
+	if err := copyTree(world.Overworld, destDir, skipPaperFiles); err != nil {
+		return fmt.Errorf("オーバーワールドのコピーに失敗しました: %w", err)
+	}
+
+	dimensions := []struct {
+		serverDir string
+		dimDir    string
+	}{
+		{world.Nether, netherDimDir},
+		{world.End, endDimDir},
+	}
+	for _, dim := range dimensions {
+		if dim.serverDir == "" {
+			log.Printf("情報: %s に対応するディメンションのフォルダがないためスキップします。", dim.dimDir)
+			continue
+		}
+		src := filepath.Join(dim.serverDir, dim.dimDir)
+		if _, err := os.Stat(src); err != nil {
+			log.Printf("情報: '%s' が見つからないためスキップします。", src)
+			continue
+		}
+		log.Printf("'%s' を %s としてコピーしています...", src, dim.dimDir)
+		if err := copyTree(src, filepath.Join(destDir, dim.dimDir), skipPaperFiles); err != nil {
+			return fmt.Errorf("%s のコピーに失敗しました: %w", dim.dimDir, err)
+		}
+	}
+	return nil
+}
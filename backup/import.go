@@ -0,0 +1,242 @@
+package main
+
+import (
+	"archive/zip"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runImport はバニラのシングルプレイ用ワールド (zip またはフォルダ) をBukkit/Paperのレイアウトに変換して取り込みます。
+//
+//	backup import [-server-dir <サーバーディレクトリ>] [-force] <zipファイル または ワールドフォルダ>
+//
+// 既存のワールドは取り込み前に BACKUP_OUTPUT_PATH へアーカイブします。
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	serverDir := fs.String("server-dir", os.Getenv("MINECRAFT_SERVER_DIR"), "取り込み先のサーバーディレクトリ (server.properties がある場所)")
+	serverDataVersion := fs.Int("server-data-version", 0, "サーバーのDataVersion (省略時は既存ワールドの level.dat から取得)")
+	force := fs.Bool("force", false, "DataVersion の互換性チェックに失敗しても取り込む")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("取り込むワールドのzipファイルまたはフォルダを1つ指定してください")
+	}
+	source := fs.Arg(0)
+	if *serverDir == "" {
+		return fmt.Errorf("サーバーディレクトリが指定されていません (-server-dir または 環境変数 MINECRAFT_SERVER_DIR)")
+	}
+
+	levelName, err := readLevelName(*serverDir)
+	if err != nil {
+		return err
+	}
+
+	workDir, err := os.MkdirTemp("", "minecraft-import-")
+	if err != nil {
+		return fmt.Errorf("作業ディレクトリの作成に失敗しました: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	// 1. 取り込むワールドの場所を特定する
+	sourceDir := source
+	if strings.HasSuffix(strings.ToLower(source), ".zip") {
+		sourceDir = filepath.Join(workDir, "source")
+		log.Printf("'%s' を展開しています...", source)
+		if err := extractZip(source, sourceDir); err != nil {
+			return err
+		}
+	}
+	worldRoot, err := findVanillaWorldRoot(sourceDir)
+	if err != nil {
+		return err
+	}
+	log.Printf("取り込むワールド: %s", worldRoot)
+
+	// 2. DataVersion の互換性をチェックする
+	target := serverWorld{
+		Overworld: filepath.Join(*serverDir, levelName),
+		Nether:    filepath.Join(*serverDir, levelName+"_nether"),
+		End:       filepath.Join(*serverDir, levelName+"_the_end"),
+	}
+	if err := checkDataVersion(filepath.Join(worldRoot, "level.dat"), target.Overworld, int32(*serverDataVersion)); err != nil {
+		if !*force {
+			return err
+		}
+		log.Printf("警告: %v (-force が指定されたため続行します)", err)
+	}
+
+	// 3. 既存のワールドをバックアップしてから削除する
+	log.Println("警告: 取り込みはサーバーを停止した状態で行ってください。")
+	if err := backupExistingWorld(target, levelName); err != nil {
+		return err
+	}
+	for _, dir := range []string{target.Overworld, target.Nether, target.End} {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("既存のワールド '%s' の削除に失敗しました: %w", dir, err)
+		}
+	}
+
+	// 4. Paperのレイアウトに分割して配置する
+	if err := installServerLayout(worldRoot, target); err != nil {
+		return err
+	}
+	log.Printf("インポートが完了しました: %s", target.Overworld)
+	return nil
+}
+
+// findVanillaWorldRoot は dir 以下で level.dat を含む最も浅いディレクトリを返します。
+// zipにはワールドフォルダがそのまま入っている場合と、中身だけが入っている場合があります。
+func findVanillaWorldRoot(dir string) (string, error) {
+	root := ""
+	rootDepth := -1
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() != "level.dat" {
+			return nil
+		}
+		worldDir := filepath.Dir(path)
+		depth := strings.Count(worldDir, string(filepath.Separator))
+		if rootDepth < 0 || depth < rootDepth {
+			root, rootDepth = worldDir, depth
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("'%s' の走査に失敗しました: %w", dir, err)
+	}
+	if root == "" {
+		return "", fmt.Errorf("'%s' に level.dat が見つかりません。Minecraftのワールドではない可能性があります", dir)
+	}
+	return root, nil
+}
+
+// checkDataVersion は取り込むワールドがサーバーで読み込めるかを DataVersion で確認します。
+// サーバーは古いワールドをアップグレードできますが、新しいバージョンで保存されたワールドは読み込めません。
+func checkDataVersion(levelDat, currentWorld string, serverVersion int32) error {
+	importVersion, err := readLevelDataVersion(levelDat)
+	if err != nil {
+		return err
+	}
+
+	if serverVersion == 0 {
+		serverVersion, err = readLevelDataVersion(filepath.Join(currentWorld, "level.dat"))
+		if err != nil {
+			log.Printf("警告: サーバーのDataVersionを取得できないため互換性チェックをスキップします: %v", err)
+			return nil
+		}
+	}
+
+	log.Printf("DataVersion: 取り込むワールド %d / サーバー %d", importVersion, serverVersion)
+	switch {
+	case importVersion > serverVersion:
+		return fmt.Errorf("取り込むワールド (DataVersion %d) はサーバー (DataVersion %d) より新しいバージョンで保存されています", importVersion, serverVersion)
+	case importVersion < serverVersion:
+		log.Printf("警告: 取り込むワールドは古いバージョンで保存されています。サーバー起動時にアップグレードされ、元のバージョンでは開けなくなります。")
+	}
+	return nil
+}
+
+// backupExistingWorld は取り込み先に既にあるワールドをローカルにアーカイブします。
+func backupExistingWorld(world serverWorld, levelName string) error {
+	var existing []string
+	for _, dir := range []string{world.Overworld, world.Nether, world.End} {
+		if _, err := os.Stat(dir); err == nil {
+			existing = append(existing, dir)
+		}
+	}
+	if len(existing) == 0 {
+		log.Println("情報: 既存のワールドがないためバックアップは行いません。")
+		return nil
+	}
+
+	compression, err := loadCompressionOptions()
+	if err != nil {
+		return err
+	}
+	outputDir := os.Getenv("BACKUP_OUTPUT_PATH")
+	if outputDir == "" {
+		outputDir = filepath.Dir(world.Overworld)
+	}
+	archivePath := filepath.Join(outputDir, fmt.Sprintf("%s_pre_import_%s%s", levelName, time.Now().Format("20060102_150405"), archiveExtension(compression.Method)))
+
+	log.Printf("既存のワールドを '%s' にバックアップしています...", archivePath)
+	if err := createArchive(existing, archivePath, compression); err != nil {
+		return fmt.Errorf("既存のワールドのバックアップに失敗しました: %w", err)
+	}
+	return nil
+}
+
+// installServerLayout はバニラのワールドをBukkit/Paperのレイアウトで配置します。
+// DIM-1 と DIM1 はそれぞれ <level-name>_nether と <level-name>_the_end に移し、
+// 各ディメンションのフォルダには level.dat を複製します (CraftBukkitの移行処理と同じ構成)。
+func installServerLayout(worldRoot string, target serverWorld) error {
+	log.Printf("オーバーワールドを '%s' に配置しています...", target.Overworld)
+	err := copyTree(worldRoot, target.Overworld, func(relPath string, info os.FileInfo) bool {
+		return relPath == netherDimDir || relPath == endDimDir || info.Name() == "session.lock"
+	})
+	if err != nil {
+		return fmt.Errorf("オーバーワールドの配置に失敗しました: %w", err)
+	}
+
+	dimensions := []struct {
+		dimDir    string
+		serverDir string
+	}{
+		{netherDimDir, target.Nether},
+		{endDimDir, target.End},
+	}
+	for _, dim := range dimensions {
+		src := filepath.Join(worldRoot, dim.dimDir)
+		if _, err := os.Stat(src); err != nil {
+			log.Printf("情報: %s がないためスキップします。", dim.dimDir)
+			continue
+		}
+		log.Printf("%s を '%s' に配置しています...", dim.dimDir, dim.serverDir)
+		if err := copyTree(src, filepath.Join(dim.serverDir, dim.dimDir), nil); err != nil {
+			return fmt.Errorf("%s の配置に失敗しました: %w", dim.dimDir, err)
+		}
+		if err := copyFile(filepath.Join(worldRoot, "level.dat"), filepath.Join(dim.serverDir, "level.dat"), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractZip はzipファイルを destDir に展開します。
+func extractZip(zipPath, destDir string) error {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return fmt.Errorf("zipファイル '%s' を開くことができませんでした: %w", zipPath, err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		target, err := safeJoin(destDir, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("ディレクトリ '%s' の作成に失敗しました: %w", target, err)
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("zip内のファイル '%s' を開くことができませんでした: %w", f.Name, err)
+		}
+		err = writeFileFrom(rc, target, 0644)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,36 +4,33 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
+	MinecraftServerDir   string
 	MinecraftWorldDirs   []string
 	BackupOutputPath     string
 	BackupFileNamePrefix string
 	S3BucketName         string
 	AWSRegion            string
+	Compression          CompressionOptions
+	DiscordWebhookURL    string
+	ShareAfterBackup     bool
+	ShareLinkExpiry      time.Duration
 }
 
 func LoadConfig() (*Config, error) {
-	worldDirsStr := os.Getenv("MINECRAFT_WORLD_DIRS")
-	if worldDirsStr == "" {
-		return nil, fmt.Errorf("環境変数 MINECRAFT_WORLD_DIRS が設定されていません。(例: /path/to/world,/path/to/world_nether)")
-	}
-
-	// カンマで分割し、各パスの前後の空白を削除
-	var worldDirs []string
-	for _, p := range strings.Split(worldDirsStr, ",") {
-		trimmedPath := strings.TrimSpace(p)
-		if trimmedPath != "" {
-			worldDirs = append(worldDirs, trimmedPath)
-		}
-	}
-	if len(worldDirs) == 0 {
-		return nil, fmt.Errorf("環境変数 MINECRAFT_WORLD_DIRS に有効なパスが指定されていません。")
+	worldDirs, err := loadWorldDirs()
+	if err != nil {
+		return nil, err
 	}
 
 	cfg := &Config{
+		MinecraftServerDir:   os.Getenv("MINECRAFT_SERVER_DIR"),
 		MinecraftWorldDirs:   worldDirs,
 		BackupOutputPath:     os.Getenv("BACKUP_OUTPUT_PATH"),
 		BackupFileNamePrefix: os.Getenv("BACKUP_FILE_NAME_PREFIX"),
@@ -55,5 +52,105 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("環境変数 AWS_REGION が設定されていません。")
 	}
 
+	compression, err := loadCompressionOptions()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Compression = compression
+
+	// バックアップ後に署名付きURLをDiscordへ投稿するか (オプション)
+	cfg.DiscordWebhookURL = os.Getenv("DISCORD_WEBHOOK_URL")
+	if v := os.Getenv("SHARE_AFTER_BACKUP"); v != "" {
+		cfg.ShareAfterBackup, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("環境変数 SHARE_AFTER_BACKUP の値 '%s' は無効です。(true または false)", v)
+		}
+	}
+	cfg.ShareLinkExpiry, err = loadShareLinkExpiry()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ShareAfterBackup && cfg.DiscordWebhookURL == "" {
+		log.Println("警告: SHARE_AFTER_BACKUP が有効ですが DISCORD_WEBHOOK_URL が設定されていません。URLはログにのみ出力されます。")
+	}
+
 	return cfg, nil
 }
+
+// loadWorldDirs はバックアップ対象のワールドディレクトリを決定します。
+// MINECRAFT_WORLD_DIRS が設定されていればそれを優先し、なければ MINECRAFT_SERVER_DIR から自動検出します。
+func loadWorldDirs() ([]string, error) {
+	worldDirsStr := os.Getenv("MINECRAFT_WORLD_DIRS")
+	if worldDirsStr != "" {
+		// カンマで分割し、各パスの前後の空白を削除
+		var worldDirs []string
+		for _, p := range strings.Split(worldDirsStr, ",") {
+			trimmedPath := strings.TrimSpace(p)
+			if trimmedPath != "" {
+				worldDirs = append(worldDirs, trimmedPath)
+			}
+		}
+		if len(worldDirs) == 0 {
+			return nil, fmt.Errorf("環境変数 MINECRAFT_WORLD_DIRS に有効なパスが指定されていません。")
+		}
+		log.Printf("環境変数 MINECRAFT_WORLD_DIRS で指定されたワールドを使用します: %s", worldDirs)
+		return worldDirs, nil
+	}
+
+	serverDir := os.Getenv("MINECRAFT_SERVER_DIR")
+	if serverDir == "" {
+		return nil, fmt.Errorf("環境変数 MINECRAFT_SERVER_DIR または MINECRAFT_WORLD_DIRS が設定されていません。(例: /opt/minecraft)")
+	}
+	worldDirs, err := discoverWorldDirs(serverDir)
+	if err != nil {
+		return nil, fmt.Errorf("ワールドディレクトリの自動検出に失敗しました: %w", err)
+	}
+	log.Printf("'%s' からワールドを自動検出しました: %s", serverDir, worldDirs)
+	return worldDirs, nil
+}
+
+// loadCompressionMethod は環境変数 BACKUP_COMPRESSION から圧縮方式を読み込みます (未設定の場合は gzip)。
+func loadCompressionMethod() (string, error) {
+	method := strings.ToLower(strings.TrimSpace(os.Getenv("BACKUP_COMPRESSION")))
+	switch method {
+	case "":
+		return CompressionGzip, nil
+	case CompressionGzip, CompressionPgzip, CompressionZstd:
+		return method, nil
+	default:
+		return "", fmt.Errorf("環境変数 BACKUP_COMPRESSION の値 '%s' は無効です。(gzip, pgzip, zstd のいずれか)", method)
+	}
+}
+
+// loadCompressionOptions は圧縮方式に関する環境変数を読み込みます。
+func loadCompressionOptions() (CompressionOptions, error) {
+	opts := CompressionOptions{
+		Workers:   runtime.NumCPU(),
+		BlockSize: 1 << 20, // 1 MiB
+	}
+	var err error
+	if opts.Method, err = loadCompressionMethod(); err != nil {
+		return opts, err
+	}
+
+	if v := os.Getenv("BACKUP_COMPRESSION_WORKERS"); v != "" {
+		workers, err := strconv.Atoi(v)
+		if err != nil || workers < 1 {
+			return opts, fmt.Errorf("環境変数 BACKUP_COMPRESSION_WORKERS の値 '%s' は無効です。(1以上の整数)", v)
+		}
+		opts.Workers = workers
+	}
+	if v := os.Getenv("BACKUP_COMPRESSION_BLOCK_SIZE_KB"); v != "" {
+		kb, err := strconv.Atoi(v)
+		// pgzipはブロックサイズが小さすぎるとエラーになるため下限を設ける
+		if err != nil || kb < 64 {
+			return opts, fmt.Errorf("環境変数 BACKUP_COMPRESSION_BLOCK_SIZE_KB の値 '%s' は無効です。(64以上の整数)", v)
+		}
+		opts.BlockSize = kb * 1024
+	}
+	if opts.Method == CompressionZstd {
+		log.Printf("情報: BACKUP_COMPRESSION=zstd の場合、最新のバックアップは %s にアップロードされます (gzip の latest_world.tar.gz ではありません)。", latestBackupKey(opts.Method))
+	}
+
+	return opts, nil
+}
@@ -2,14 +2,46 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
+
+	"common/failover"
+	"common/sdnotify"
 )
 
+// 各段階 (圧縮、アップロード) を始めるときに延長する停止のタイムアウト
+const stageTimeout = 15 * time.Minute
+
 func main() {
+	// サブコマンドの振り分け (引数なしの場合は従来どおりバックアップを実行)
+	if len(os.Args) > 1 {
+		var err error
+		switch os.Args[1] {
+		case "export":
+			err = runExport(os.Args[2:])
+		case "import":
+			err = runImport(os.Args[2:])
+		case "share":
+			err = runShare(os.Args[2:])
+		default:
+			log.Fatalf("不明なサブコマンドです: %s (使用可能: export, import, share)", os.Args[1])
+		}
+		if err != nil {
+			log.Fatalf("%s に失敗しました: %v", os.Args[1], err)
+		}
+		return
+	}
+
+	runBackup()
+}
+
+// runBackup はワールドを圧縮してS3にアップロードします。
+func runBackup() {
 	// 設定を読み込む
 	cfg, err := LoadConfig()
 	if err != nil {
@@ -20,27 +52,61 @@ func main() {
 
 	// バックアップファイル名を生成
 	currentTime := time.Now().Format("20060102_150405")
-	outputFileName := fmt.Sprintf("%s_%s.tar.gz", cfg.BackupFileNamePrefix, currentTime)
+	extension := archiveExtension(cfg.Compression.Method)
+	outputFileName := fmt.Sprintf("%s_%s%s", cfg.BackupFileNamePrefix, currentTime, extension)
 	fullBackupPath := filepath.Join(cfg.BackupOutputPath, outputFileName)
 
 	log.Printf("ワールドディレクトリ '%s' を '%s' に圧縮中...", cfg.MinecraftWorldDirs, fullBackupPath)
+	notifyProgress("ワールドを圧縮中: " + outputFileName)
+
+	// サーバーが稼働中なら、圧縮中は自動保存を止めておく
+	resumeSaving := pauseWorldSaving(context.Background())
 
 	// 圧縮処理を呼び出す
-	if err := createTarGz(cfg.MinecraftWorldDirs, fullBackupPath); err != nil {
+	err = createArchive(cfg.MinecraftWorldDirs, fullBackupPath, cfg.Compression)
+	resumeSaving()
+	if err != nil {
 		log.Fatalf("ワールドの圧縮に失敗しました: %v", err)
 	}
 
 	log.Printf("ワールドの圧縮が完了しました: %s", fullBackupPath)
 
 	// S3へのアップロード
-	s3ObjectKey := "minecraft_backups/latest_world.tar.gz"
-	ctx := context.Background()                                    // AWS SDK操作のためのContext
+	// 日時付きのキーにアップロードしてから latest_world にコピーする。latest_world は次のバックアップで上書きされるため、
+	// 置き換えのインスタンスの復元や共有には日時付きのキーを使う
+	s3ObjectKey := backupS3Prefix + outputFileName
+	ctx := context.Background() // AWS SDK操作のためのContext
 
 	// S3アップロード処理を呼び出す
-	if err := uploadToS3(ctx, fullBackupPath, cfg.S3BucketName, s3ObjectKey, cfg.AWSRegion); err != nil {
+	notifyProgress("S3にアップロード中: " + outputFileName)
+	metadata := map[string]string{
+		metadataWorlds:    worldNames(cfg.MinecraftWorldDirs),
+		metadataCreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := uploadToS3(ctx, fullBackupPath, cfg.S3BucketName, s3ObjectKey, cfg.AWSRegion, metadata); err != nil {
 		log.Fatalf("S3へのアップロードに失敗しました: %v", err)
 	}
 
+	// 最新のバックアップの場所を記録する (spot-handler が中断イベントに載せる)
+	if err := recordLatestBackup(cfg, s3ObjectKey); err != nil {
+		log.Printf("最新のバックアップの記録に失敗しました: %v", err)
+	}
+
+	// 固定のキー (latest_world) を読むスクリプトのために、最新のバックアップとしてコピーする
+	latestKey := latestBackupKey(cfg.Compression.Method)
+	notifyProgress("最新のバックアップとしてコピー中: " + latestKey)
+	if err := copyS3Object(ctx, cfg.S3BucketName, s3ObjectKey, latestKey, cfg.AWSRegion); err != nil {
+		log.Fatalf("最新のバックアップのコピーに失敗しました: %v", err)
+	}
+	log.Printf("s3://%s/%s を s3://%s/%s にコピーしました。", cfg.S3BucketName, s3ObjectKey, cfg.S3BucketName, latestKey)
+
+	// オプション: バックアップの署名付きURLを共有する (失敗してもバックアップ自体は成功扱い)
+	if cfg.ShareAfterBackup {
+		if err := shareObject(ctx, cfg.S3BucketName, s3ObjectKey, cfg.AWSRegion, cfg.ShareLinkExpiry, cfg.DiscordWebhookURL); err != nil {
+			log.Printf("バックアップの共有に失敗しました: %v", err)
+		}
+	}
+
 	// S3へのアップロードが成功したらローカルファイルを削除
 	log.Printf("S3へのアップロードが成功したため、ローカルファイル '%s' を削除します。", fullBackupPath)
 	if err := os.Remove(fullBackupPath); err != nil {
@@ -51,3 +117,44 @@ func main() {
 
 	log.Println("Minecraftのバックアッププロセスが完了しました。")
 }
+
+// recordLatestBackup はアップロードしたバックアップの場所をJSONファイルに書き込みます。
+// 書き込み先は LATEST_BACKUP_FILE (未設定の場合は BACKUP_OUTPUT_PATH/latest_backup.json) です。
+func recordLatestBackup(cfg *Config, key string) error {
+	path := os.Getenv("LATEST_BACKUP_FILE")
+	if path == "" {
+		path = filepath.Join(cfg.BackupOutputPath, "latest_backup.json")
+	}
+	data, err := json.MarshalIndent(failover.BackupLocation{
+		Bucket:    cfg.S3BucketName,
+		Key:       key,
+		CreatedAt: time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	// 読み込み途中のファイルを読まれないよう、一時ファイルに書いてから置き換える
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// notifyProgress は systemd に進捗を送り、停止のタイムアウトを延長します。
+// minecraft.service の ExecStop で実行した場合に、圧縮やアップロードの途中で TimeoutStopSec を超えて強制終了されないようにします。
+// systemd の外で実行した場合は何もしません。
+func notifyProgress(status string) {
+	if _, err := sdnotify.Notify(sdnotify.Status(status), sdnotify.ExtendTimeout(stageTimeout)); err != nil {
+		log.Printf("systemdへの通知に失敗しました: %v", err)
+	}
+}
+
+// worldNames はワールドディレクトリのフォルダ名をカンマ区切りで返します。
+func worldNames(dirs []string) string {
+	names := make([]string, len(dirs))
+	for i, dir := range dirs {
+		names[i] = filepath.Base(dir)
+	}
+	return strings.Join(names, ",")
+}
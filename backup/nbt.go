@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// NBTのタグ種別
+const (
+	tagEnd byte = iota
+	tagByte
+	tagShort
+	tagInt
+	tagLong
+	tagFloat
+	tagDouble
+	tagByteArray
+	tagString
+	tagList
+	tagCompound
+	tagIntArray
+	tagLongArray
+)
+
+// readLevelDataVersion は level.dat (gzip圧縮されたNBT) から Data.DataVersion を読み取ります。
+// DataVersion はワールドを保存したMinecraftのバージョンを表す整数です。
+func readLevelDataVersion(path string) (int32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' を開くことができませんでした: %w", path, err)
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' のgzip展開に失敗しました: %w", path, err)
+	}
+	defer gr.Close()
+	r := bufio.NewReader(gr)
+
+	// ルートは名前付きのCompoundタグ
+	rootType, err := r.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("'%s' のNBTの読み込みに失敗しました: %w", path, err)
+	}
+	if rootType != tagCompound {
+		return 0, fmt.Errorf("'%s' のルートタグがCompoundではありません (type=%d)", path, rootType)
+	}
+	if _, err := readNBTString(r); err != nil {
+		return 0, err
+	}
+
+	// ルート直下の "Data" Compound の中にある "DataVersion" を探す
+	var version int32
+	found := false
+	err = walkCompound(r, func(tagType byte, name string) (bool, error) {
+		if tagType != tagCompound || name != "Data" {
+			return false, nil
+		}
+		err := walkCompound(r, func(tagType byte, name string) (bool, error) {
+			if tagType != tagInt || name != "DataVersion" {
+				return false, nil
+			}
+			if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+				return false, err
+			}
+			found = true
+			return true, nil
+		})
+		return true, err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("'%s' のNBTの読み込みに失敗しました: %w", path, err)
+	}
+	if !found {
+		return 0, fmt.Errorf("'%s' に DataVersion が含まれていません (1.9より前のワールドの可能性があります)", path)
+	}
+	return version, nil
+}
+
+// walkCompound はCompoundタグの子要素を順に走査します。
+// visit が true を返した場合、そのタグのペイロードは visit の中で読み取られたものとして扱います。
+func walkCompound(r *bufio.Reader, visit func(tagType byte, name string) (bool, error)) error {
+	for {
+		tagType, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if tagType == tagEnd {
+			return nil
+		}
+		name, err := readNBTString(r)
+		if err != nil {
+			return err
+		}
+		consumed, err := visit(tagType, name)
+		if err != nil {
+			return err
+		}
+		if !consumed {
+			if err := skipNBTPayload(r, tagType); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readNBTString は長さ付きのNBT文字列 (Modified UTF-8) を読み取ります。
+func readNBTString(r *bufio.Reader) (string, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// skipNBTPayload は指定した種別のタグのペイロードを読み飛ばします。
+func skipNBTPayload(r *bufio.Reader, tagType byte) error {
+	switch tagType {
+	case tagByte:
+		return discard(r, 1)
+	case tagShort:
+		return discard(r, 2)
+	case tagInt, tagFloat:
+		return discard(r, 4)
+	case tagLong, tagDouble:
+		return discard(r, 8)
+	case tagByteArray, tagIntArray, tagLongArray:
+		var length int32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return err
+		}
+		elemSize := map[byte]int{tagByteArray: 1, tagIntArray: 4, tagLongArray: 8}[tagType]
+		return discard(r, int(length)*elemSize)
+	case tagString:
+		_, err := readNBTString(r)
+		return err
+	case tagList:
+		elemType, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		var length int32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return err
+		}
+		for i := int32(0); i < length; i++ {
+			if err := skipNBTPayload(r, elemType); err != nil {
+				return err
+			}
+		}
+		return nil
+	case tagCompound:
+		return walkCompound(r, func(byte, string) (bool, error) { return false, nil })
+	default:
+		return fmt.Errorf("不明なNBTタグ種別です: %d", tagType)
+	}
+}
+
+func discard(r *bufio.Reader, n int) error {
+	if n < 0 {
+		return fmt.Errorf("NBTの長さが不正です: %d", n)
+	}
+	_, err := r.Discard(n)
+	return err
+}
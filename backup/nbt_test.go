@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// nbtWriter はテスト用の level.dat を組み立てる
+type nbtWriter struct {
+	bytes.Buffer
+}
+
+func (w *nbtWriter) tag(tagType byte, name string) *nbtWriter {
+	w.WriteByte(tagType)
+	w.str(name)
+	return w
+}
+
+func (w *nbtWriter) str(s string) *nbtWriter {
+	binary.Write(w, binary.BigEndian, uint16(len(s)))
+	w.WriteString(s)
+	return w
+}
+
+func (w *nbtWriter) put(v any) *nbtWriter {
+	binary.Write(w, binary.BigEndian, v)
+	return w
+}
+
+func (w *nbtWriter) end() *nbtWriter {
+	w.WriteByte(tagEnd)
+	return w
+}
+
+// writeLevelDat は root を名前なしのルートCompoundとしてgzip圧縮して書き込む
+func writeLevelDat(t *testing.T, dir string, root func(w *nbtWriter)) string {
+	t.Helper()
+	w := &nbtWriter{}
+	w.tag(tagCompound, "")
+	root(w)
+	w.end()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write(w.Bytes())
+	gw.Close()
+	path := filepath.Join(dir, "level.dat")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// levelData は DataVersion の前に、読み飛ばす必要のある種類のタグを並べた Data を書く
+func levelData(version int32) func(w *nbtWriter) {
+	return func(w *nbtWriter) {
+		// Data より前にあるタグも読み飛ばす
+		w.tag(tagInt, "DataVersion").put(int32(-1))
+		w.tag(tagCompound, "Data")
+		w.tag(tagByte, "hardcore").put(int8(0))
+		w.tag(tagShort, "Difficulty").put(int16(2))
+		w.tag(tagLong, "RandomSeed").put(int64(1234567890123))
+		w.tag(tagFloat, "BorderDamagePerBlock").put(float32(0.2))
+		w.tag(tagDouble, "BorderCenterX").put(float64(0))
+		w.tag(tagString, "LevelName").str("world")
+		w.tag(tagByteArray, "Bytes").put(int32(3)).put([]byte{1, 2, 3})
+		w.tag(tagIntArray, "WanderingTraderId").put(int32(4)).put([]int32{1, 2, 3, 4})
+		w.tag(tagLongArray, "Longs").put(int32(2)).put([]int64{1, 2})
+		w.tag(tagList, "ServerBrands").put(tagString).put(int32(2)).str("vanilla").str("paper")
+		// Compound のリストと入れ子のCompound
+		w.tag(tagList, "ScheduledEvents").put(tagCompound).put(int32(1))
+		w.tag(tagString, "Name").str("event").end()
+		w.tag(tagCompound, "Version").tag(tagInt, "Id").put(int32(9999)).tag(tagString, "Name").str("1.21").end()
+		w.tag(tagInt, "DataVersion").put(version)
+		w.tag(tagString, "After").str("ignored")
+		w.end()
+	}
+}
+
+func TestReadLevelDataVersion(t *testing.T) {
+	path := writeLevelDat(t, t.TempDir(), levelData(3955))
+	got, err := readLevelDataVersion(path)
+	if err != nil {
+		t.Fatalf("readLevelDataVersion: %v", err)
+	}
+	if got != 3955 {
+		t.Errorf("DataVersion = %d, want 3955", got)
+	}
+}
+
+func TestReadLevelDataVersionErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(t *testing.T, dir string) string
+	}{
+		{"missing file", func(t *testing.T, dir string) string {
+			return filepath.Join(dir, "level.dat")
+		}},
+		{"not gzip", func(t *testing.T, dir string) string {
+			path := filepath.Join(dir, "level.dat")
+			os.WriteFile(path, []byte("not nbt"), 0o644)
+			return path
+		}},
+		{"no DataVersion", func(t *testing.T, dir string) string {
+			// 1.9より前のワールドには DataVersion がない
+			return writeLevelDat(t, dir, func(w *nbtWriter) {
+				w.tag(tagCompound, "Data").tag(tagString, "LevelName").str("old").end()
+			})
+		}},
+		{"DataVersion outside Data", func(t *testing.T, dir string) string {
+			return writeLevelDat(t, dir, func(w *nbtWriter) {
+				w.tag(tagInt, "DataVersion").put(int32(3955))
+			})
+		}},
+		{"truncated", func(t *testing.T, dir string) string {
+			return writeLevelDat(t, dir, func(w *nbtWriter) {
+				w.tag(tagCompound, "Data").tag(tagString, "LevelName").put(uint16(100))
+			})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.write(t, t.TempDir())
+			if v, err := readLevelDataVersion(path); err == nil {
+				t.Errorf("readLevelDataVersion = %d, want an error", v)
+			}
+		})
+	}
+}
+
+func TestCheckDataVersion(t *testing.T) {
+	importDir := t.TempDir()
+	levelDat := writeLevelDat(t, importDir, levelData(3955))
+
+	tests := []struct {
+		name          string
+		serverVersion int32
+		wantErr       bool
+	}{
+		{"same version", 3955, false},
+		{"older world is upgraded", 4000, false},
+		{"newer world cannot be loaded", 3700, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDataVersion(levelDat, t.TempDir(), tt.serverVersion)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkDataVersion = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("server version from the current world", func(t *testing.T) {
+		currentWorld := t.TempDir()
+		writeLevelDat(t, currentWorld, levelData(3700))
+		if err := checkDataVersion(levelDat, currentWorld, 0); err == nil {
+			t.Error("checkDataVersion succeeded, want an error for the newer world")
+		}
+	})
+
+	t.Run("no current world", func(t *testing.T) {
+		// サーバーのバージョンがわからない場合はチェックを飛ばす
+		if err := checkDataVersion(levelDat, t.TempDir(), 0); err != nil {
+			t.Errorf("checkDataVersion = %v, want nil", err)
+		}
+	})
+}
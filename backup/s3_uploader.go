@@ -5,43 +5,250 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws" // SDKの型を扱うために必要
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 // uploadToS3 は指定されたローカルファイルをS3にアップロードします。
-func uploadToS3(ctx context.Context, filePath, bucketName, objectKey, region string) error {
-    log.Printf("S3にアップロード中: '%s' から s3://%s/%s (リージョン: %s)", filePath, bucketName, objectKey, region)
-
-    // AWS SDK設定をロード (IAMロール、環境変数などを自動的に検出)
-    cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
-    if err != nil {
-        return fmt.Errorf("AWS設定のロードに失敗しました: %w", err)
-    }
-    // S3クライアントとアップロードマネージャーを初期化
-    s3Client := s3.NewFromConfig(cfg)
-    uploader := manager.NewUploader(s3Client)
-
-    // アップロードするローカルファイルを開く
-    f, err := os.Open(filePath)
-    if err != nil {
-        return fmt.Errorf("ローカルファイル '%s' を開くことができませんでした: %w", filePath, err)
-    }
-    defer f.Close() // 関数終了時にファイルを確実にクローズ
-
-    // S3へのアップロードを実行
-    _, err = uploader.Upload(ctx, &s3.PutObjectInput{
-        Bucket: aws.String(bucketName), // S3バケット名
-        Key:    aws.String(objectKey),  // S3オブジェクトキー (バケット内のパス+ファイル名)
-        Body:   f,                      // ファイルの内容をio.Readerとして渡す
-    })
-    if err != nil {
-        return fmt.Errorf("S3へのアップロードに失敗しました: %w", err)
-    }
-
-    log.Printf("S3へのアップロードが完了しました: s3://%s/%s", bucketName, objectKey)
-    return nil
+// metadata はS3オブジェクトのユーザー定義メタデータとして保存されます (nil可)。
+func uploadToS3(ctx context.Context, filePath, bucketName, objectKey, region string, metadata map[string]string) error {
+	log.Printf("S3にアップロード中: '%s' から s3://%s/%s (リージョン: %s)", filePath, bucketName, objectKey, region)
+
+	// AWS SDK設定をロード (IAMロール、環境変数などを自動的に検出)
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return fmt.Errorf("AWS設定のロードに失敗しました: %w", err)
+	}
+	// S3クライアントとアップロードマネージャーを初期化
+	s3Client := s3.NewFromConfig(cfg)
+	uploader := manager.NewUploader(s3Client)
+
+	// アップロードするローカルファイルを開く
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("ローカルファイル '%s' を開くことができませんでした: %w", filePath, err)
+	}
+	defer f.Close() // 関数終了時にファイルを確実にクローズ
+
+	// S3へのアップロードを実行
+	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:   aws.String(bucketName), // S3バケット名
+		Key:      aws.String(objectKey),  // S3オブジェクトキー (バケット内のパス+ファイル名)
+		Body:     f,                      // ファイルの内容をio.Readerとして渡す
+		Metadata: metadata,               // ワールド名などの付加情報
+	})
+	if err != nil {
+		return fmt.Errorf("S3へのアップロードに失敗しました: %w", err)
+	}
+
+	log.Printf("S3へのアップロードが完了しました: s3://%s/%s", bucketName, objectKey)
+	return nil
+}
+
+// downloadFromS3 はS3のオブジェクトをローカルファイルにダウンロードします。
+func downloadFromS3(ctx context.Context, bucketName, objectKey, filePath, region string) error {
+	log.Printf("S3からダウンロード中: s3://%s/%s から '%s' (リージョン: %s)", bucketName, objectKey, filePath, region)
+
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return fmt.Errorf("AWS設定のロードに失敗しました: %w", err)
+	}
+	downloader := manager.NewDownloader(s3.NewFromConfig(cfg))
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("ディレクトリ '%s' の作成に失敗しました: %w", filepath.Dir(filePath), err)
+	}
+	f, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("ローカルファイル '%s' を作成できませんでした: %w", filePath, err)
+	}
+	defer f.Close()
+
+	_, err = downloader.Download(ctx, f, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return fmt.Errorf("S3からのダウンロードに失敗しました: %w", err)
+	}
+
+	log.Printf("S3からのダウンロードが完了しました: %s", filePath)
+	return nil
+}
+
+// presignedLink は署名付きURLと、共有時に表示するオブジェクトの情報です。
+type presignedLink struct {
+	URL string
+	// URLが実際に使えなくなる時刻。一時的な認証情報で署名した場合は、その認証情報の期限で切れます
+	Expires      time.Time
+	Size         int64
+	LastModified time.Time
+	Metadata     map[string]string
+}
+
+// presignDownloadURL はS3オブジェクトをダウンロードするための署名付きGET URLを発行します。
+// AWSの認証情報を持たない相手とも、有効期間内であれば共有できます。
+func presignDownloadURL(ctx context.Context, bucketName, objectKey, region string, expiry time.Duration) (*presignedLink, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("AWS設定のロードに失敗しました: %w", err)
+	}
+	s3Client := s3.NewFromConfig(cfg)
+
+	// オブジェクトが存在することを確認し、サイズとメタデータを取得
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("S3オブジェクト s3://%s/%s の情報を取得できませんでした: %w", bucketName, objectKey, err)
+	}
+
+	presigned, err := s3.NewPresignClient(s3Client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return nil, fmt.Errorf("署名付きURLの発行に失敗しました: %w", err)
+	}
+
+	link := &presignedLink{
+		URL:      presigned.URL,
+		Expires:  time.Now().Add(expiry),
+		Size:     aws.ToInt64(head.ContentLength),
+		Metadata: head.Metadata,
+	}
+	if head.LastModified != nil {
+		link.LastModified = *head.LastModified
+	}
+
+	// インスタンスロールなどの一時的な認証情報で署名したURLは、指定した有効期間に関わらず
+	// 認証情報の期限 (通常は数時間以内) で使えなくなる
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("AWSの認証情報の取得に失敗しました: %w", err)
+	}
+	if creds.CanExpire && creds.Expires.Before(link.Expires) {
+		log.Printf("警告: 一時的な認証情報で署名したため、URLは指定した有効期間 (%s) より早く %s に使えなくなります。長期間共有するには長期の認証情報 (IAMユーザーのアクセスキー) で実行してください。",
+			expiry, creds.Expires.Local().Format(time.RFC3339))
+		link.Expires = creds.Expires
+	}
+	return link, nil
+}
+
+// CopyObject で一度にコピーできるサイズの上限 (5 GiB) と、それより大きい場合に分割してコピーする1パートのサイズ
+const (
+	maxSingleCopySize = 5 << 30
+	copyPartSize      = 512 << 20
+)
+
+// copyS3Object は同じバケット内でS3オブジェクトをコピーします。メタデータもコピーされます。
+// CopyObject は 5 GiB を超えるオブジェクトをコピーできないため、大きいワールドは UploadPartCopy で分割してコピーします。
+func copyS3Object(ctx context.Context, bucketName, srcKey, dstKey, region string) error {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return fmt.Errorf("AWS設定のロードに失敗しました: %w", err)
+	}
+	s3Client := s3.NewFromConfig(cfg)
+
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(srcKey),
+	})
+	if err != nil {
+		return fmt.Errorf("S3オブジェクト s3://%s/%s の情報を取得できませんでした: %w", bucketName, srcKey, err)
+	}
+	if aws.ToInt64(head.ContentLength) > maxSingleCopySize {
+		err = multipartCopy(ctx, s3Client, bucketName, srcKey, dstKey, head, copyPartSize)
+	} else {
+		_, err = s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(bucketName),
+			CopySource: aws.String(bucketName + "/" + srcKey),
+			Key:        aws.String(dstKey),
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("s3://%s/%s から s3://%s/%s へのコピーに失敗しました: %w", bucketName, srcKey, bucketName, dstKey, err)
+	}
+	return nil
+}
+
+// multipartCopy はオブジェクトを partSize ごとに UploadPartCopy でコピーします。
+// 途中で失敗した場合は、アップロード済みのパートが課金され続けないようマルチパートアップロードを中止します。
+func multipartCopy(ctx context.Context, s3Client *s3.Client, bucketName, srcKey, dstKey string, head *s3.HeadObjectOutput, partSize int64) error {
+	size := aws.ToInt64(head.ContentLength)
+	created, err := s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(dstKey),
+		ContentType: head.ContentType,
+		Metadata:    head.Metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("マルチパートアップロードの開始に失敗しました: %w", err)
+	}
+
+	var parts []types.CompletedPart
+	for start := int64(0); start < size; start += partSize {
+		end := min(start+partSize, size) - 1
+		partNumber := int32(len(parts) + 1)
+		out, err := s3Client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(bucketName),
+			Key:             aws.String(dstKey),
+			UploadId:        created.UploadId,
+			PartNumber:      aws.Int32(partNumber),
+			CopySource:      aws.String(bucketName + "/" + srcKey),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		if err != nil {
+			abortMultipartUpload(ctx, s3Client, bucketName, dstKey, created.UploadId)
+			return fmt.Errorf("パート %d のコピーに失敗しました: %w", partNumber, err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: out.CopyPartResult.ETag, PartNumber: aws.Int32(partNumber)})
+		log.Printf("コピー中: %s / %s", formatBytes(end+1), formatBytes(size))
+	}
+
+	_, err = s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucketName),
+		Key:             aws.String(dstKey),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		abortMultipartUpload(ctx, s3Client, bucketName, dstKey, created.UploadId)
+		return fmt.Errorf("マルチパートアップロードの完了に失敗しました: %w", err)
+	}
+	return nil
+}
+
+// abortMultipartUpload はマルチパートアップロードを中止します。失敗してもログに出すだけです。
+func abortMultipartUpload(ctx context.Context, s3Client *s3.Client, bucketName, key string, uploadID *string) {
+	// 呼び出し元のContextが取り消されていても中止できるようにする
+	_, err := s3Client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucketName),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+	if err != nil {
+		log.Printf("警告: マルチパートアップロード %s の中止に失敗しました: %v", aws.ToString(uploadID), err)
+	}
+}
+
+// parseS3URI は s3://bucket/key 形式のURIをバケット名とキーに分解します。
+func parseS3URI(uri string) (bucket, key string, err error) {
+	rest, ok := strings.CutPrefix(uri, "s3://")
+	if !ok {
+		return "", "", fmt.Errorf("S3のURIは s3:// で始まる必要があります: %s", uri)
+	}
+	bucket, key, _ = strings.Cut(rest, "/")
+	if bucket == "" || key == "" {
+		return "", "", fmt.Errorf("S3のURIにバケット名とキーが含まれていません: %s", uri)
+	}
+	return bucket, key, nil
 }
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultShareLinkExpiry = 24 * time.Hour
+	// SigV4の署名付きURLは最長7日間まで有効
+	maxShareLinkExpiry = 7 * 24 * time.Hour
+	// 共有のためにアップロードしたエクスポートの保存先
+	exportS3Prefix = "minecraft_exports/"
+	// 共有のために最新のバックアップをコピーする先
+	shareS3Prefix = "minecraft_shares/"
+)
+
+// S3オブジェクトに付与するメタデータのキー
+const (
+	metadataWorlds    = "worlds"
+	metadataCreatedAt = "created-at"
+)
+
+// loadShareLinkExpiry は環境変数 SHARE_LINK_EXPIRY から署名付きURLの有効期間を読み込みます。
+func loadShareLinkExpiry() (time.Duration, error) {
+	v := os.Getenv("SHARE_LINK_EXPIRY")
+	if v == "" {
+		return defaultShareLinkExpiry, nil
+	}
+	expiry, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("環境変数 SHARE_LINK_EXPIRY の値 '%s' は無効です: %w", v, err)
+	}
+	if err := validateShareLinkExpiry(expiry); err != nil {
+		return 0, err
+	}
+	return expiry, nil
+}
+
+func validateShareLinkExpiry(expiry time.Duration) error {
+	if expiry <= 0 || expiry > maxShareLinkExpiry {
+		return fmt.Errorf("署名付きURLの有効期間は 0 より大きく %s 以下である必要があります: %s", maxShareLinkExpiry, expiry)
+	}
+	return nil
+}
+
+// runShare はバックアップまたはエクスポートの署名付きダウンロードURLを発行し、Discordに投稿します。
+//
+//	backup share [-key <S3キー> | -file <ローカルのzip>] [-expires 24h] [-webhook <URL>]
+func runShare(args []string) error {
+	defaultExpiry, err := loadShareLinkExpiry()
+	if err != nil {
+		return err
+	}
+
+	// 最新のバックアップのキーは圧縮方式で拡張子が変わる
+	method, err := loadCompressionMethod()
+	if err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet("share", flag.ExitOnError)
+	key := fs.String("key", latestBackupKey(method), "共有するS3オブジェクトのキー")
+	file := fs.String("file", "", "共有するローカルファイル (export で作成したzipなど)。S3にアップロードしてから共有します")
+	expires := fs.Duration("expires", defaultExpiry, "署名付きURLの有効期間")
+	webhookURL := fs.String("webhook", os.Getenv("DISCORD_WEBHOOK_URL"), "投稿先のDiscord Webhook URL (空の場合はURLを表示するだけ)")
+	fs.Parse(args)
+
+	bucket := os.Getenv("S3_BUCKET_NAME")
+	region := os.Getenv("AWS_REGION")
+	if bucket == "" || region == "" {
+		return fmt.Errorf("環境変数 S3_BUCKET_NAME と AWS_REGION を設定してください")
+	}
+	if err := validateShareLinkExpiry(*expires); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	objectKey := *key
+	if *file != "" {
+		objectKey = exportS3Prefix + filepath.Base(*file)
+		metadata := map[string]string{metadataCreatedAt: time.Now().UTC().Format(time.RFC3339)}
+		if worlds := zipRootFolders(*file); len(worlds) > 0 {
+			metadata[metadataWorlds] = strings.Join(worlds, ",")
+		}
+		if err := uploadToS3(ctx, *file, bucket, objectKey, region, metadata); err != nil {
+			return err
+		}
+	}
+
+	return shareObject(ctx, bucket, objectKey, region, *expires, *webhookURL)
+}
+
+// shareObject は署名付きURLを発行し、webhookURL が設定されていればDiscordに投稿します。
+// 最新のバックアップ (latest_world) は次のバックアップで上書きされるため、日時付きのキーにコピーしてから共有します。
+func shareObject(ctx context.Context, bucket, objectKey, region string, expiry time.Duration, webhookURL string) error {
+	if ext, ok := strings.CutPrefix(objectKey, latestBackupPrefix); ok {
+		snapshotKey := shareS3Prefix + "world_" + time.Now().Format("20060102_150405") + ext
+		if err := copyS3Object(ctx, bucket, objectKey, snapshotKey, region); err != nil {
+			return err
+		}
+		log.Printf("最新のバックアップを共有用に s3://%s/%s にコピーしました。", bucket, snapshotKey)
+		objectKey = snapshotKey
+	}
+
+	link, err := presignDownloadURL(ctx, bucket, objectKey, region, expiry)
+	if err != nil {
+		return err
+	}
+	log.Printf("署名付きダウンロードURL (%s まで有効): %s", link.Expires.Local().Format(time.RFC3339), link.URL)
+
+	if webhookURL == "" {
+		log.Println("情報: Discord Webhook URLが設定されていないため、通知は送信しません。")
+		return nil
+	}
+
+	fields := []discordEmbedField{
+		{Name: "File", Value: path.Base(objectKey), Inline: false},
+		{Name: "Size", Value: formatBytes(link.Size), Inline: true},
+		{Name: "Expires", Value: fmt.Sprintf("<t:%d:R>", link.Expires.Unix()), Inline: true},
+	}
+	if worlds := link.Metadata[metadataWorlds]; worlds != "" {
+		fields = append(fields, discordEmbedField{Name: "Worlds", Value: worlds, Inline: false})
+	}
+	if createdAt := link.Metadata[metadataCreatedAt]; createdAt != "" {
+		fields = append(fields, discordEmbedField{Name: "Created (UTC)", Value: createdAt, Inline: true})
+	} else if !link.LastModified.IsZero() {
+		fields = append(fields, discordEmbedField{Name: "Created (UTC)", Value: link.LastModified.UTC().Format(time.RFC3339), Inline: true})
+	}
+
+	return postDiscordEmbed(ctx, webhookURL, discordEmbed{
+		Title:       "🗺️ World download available",
+		Description: "Click the title to download. The link expires automatically.",
+		URL:         link.URL,
+		Color:       3447003,
+		Fields:      fields,
+	})
+}
+
+// zipRootFolders はzipファイルの最上位にあるフォルダ名 (ワールド名) を返します。
+func zipRootFolders(zipPath string) []string {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil
+	}
+	defer zr.Close()
+
+	seen := make(map[string]bool)
+	for _, f := range zr.File {
+		root, _, found := strings.Cut(f.Name, "/")
+		if found && root != "" {
+			seen[root] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// formatBytes はバイト数を人が読みやすい形式に変換します。
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
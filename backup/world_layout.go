@@ -0,0 +1,269 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+)
+
+// バニラのワールドフォルダ内でのディメンションのサブディレクトリ名
+const (
+	netherDimDir = "DIM-1"
+	endDimDir    = "DIM1"
+)
+
+// Bukkit/Paperがワールドフォルダに追加する、バニラでは不要なファイル
+var paperOnlyFiles = map[string]bool{
+	"uid.dat":         true, // Bukkitのワールド識別子
+	"session.lock":    true, // 起動中のサーバーのロック
+	"paper-world.yml": true, // Paperのワールドごとの設定
+}
+
+// serverWorld はBukkit/Paperレイアウトにおける1つのワールドのディレクトリ構成です。
+// Nether と End は存在しない場合は空文字列になります。
+type serverWorld struct {
+	Overworld string // <level-name>
+	Nether    string // <level-name>_nether (中身は DIM-1 にある)
+	End       string // <level-name>_the_end (中身は DIM1 にある)
+}
+
+// findServerWorld はディレクトリ一覧からオーバーワールドと対応するNether/Endを探します。
+// levelName が空の場合は、_nether/_the_end で終わらないワールドが1つだけならそれをオーバーワールドとみなします。
+// 候補が複数ある場合はどれか決められないため、エラーを返します。
+func findServerWorld(dirs []string, levelName string) (*serverWorld, error) {
+	byName := make(map[string]string)
+	for _, dir := range dirs {
+		byName[filepath.Base(dir)] = dir
+	}
+
+	if levelName == "" {
+		var candidates []string
+		for _, dir := range dirs {
+			name := filepath.Base(dir)
+			if strings.HasSuffix(name, "_nether") || strings.HasSuffix(name, "_the_end") {
+				continue
+			}
+			if isWorldDir(dir) {
+				candidates = append(candidates, name)
+			}
+		}
+		if len(candidates) > 1 {
+			return nil, fmt.Errorf("オーバーワールドの候補が複数あります (%s)。-name でワールドを指定してください", strings.Join(candidates, ", "))
+		}
+		if len(candidates) == 1 {
+			levelName = candidates[0]
+		}
+	}
+
+	overworld, ok := byName[levelName]
+	if !ok || levelName == "" {
+		return nil, fmt.Errorf("オーバーワールド '%s' が見つかりません (候補: %s)", levelName, dirs)
+	}
+
+	world := &serverWorld{Overworld: overworld}
+	if dir, ok := byName[levelName+"_nether"]; ok {
+		world.Nether = dir
+	}
+	if dir, ok := byName[levelName+"_the_end"]; ok {
+		world.End = dir
+	}
+	return world, nil
+}
+
+// copyTree はディレクトリを再帰的にコピーします。
+// skip が true を返したファイル/ディレクトリはコピーしません。
+func copyTree(src, dst string, skip func(relPath string, info os.FileInfo) bool) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return fmt.Errorf("相対パスの取得に失敗しました (%s, %s): %w", src, path, err)
+		}
+		if relPath != "." && skip != nil && skip(relPath, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		target := filepath.Join(dst, relPath)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			// シンボリックリンクなどはワールドデータには含まれないので無視する
+			return nil
+		}
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+// copyFile は1つのファイルをコピーします。
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("ファイルを開くことができませんでした (%s): %w", src, err)
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("ディレクトリ '%s' の作成に失敗しました: %w", filepath.Dir(dst), err)
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("ファイルを作成できませんでした (%s): %w", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("ファイルのコピーに失敗しました (%s): %w", src, err)
+	}
+	return out.Close()
+}
+
+// writeZip は srcDir の中身を rootName フォルダ配下に収めたzipファイルを作成します。
+func writeZip(srcDir, zipPath, rootName string) error {
+	if err := os.MkdirAll(filepath.Dir(zipPath), 0755); err != nil {
+		return fmt.Errorf("出力ディレクトリ '%s' の作成に失敗しました: %w", filepath.Dir(zipPath), err)
+	}
+	file, err := os.Create(zipPath)
+	if err != nil {
+		return fmt.Errorf("出力ファイル '%s' の作成に失敗しました: %w", zipPath, err)
+	}
+	defer file.Close()
+
+	zw := zip.NewWriter(file)
+	err = filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return fmt.Errorf("相対パスの取得に失敗しました (%s, %s): %w", srcDir, path, err)
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return fmt.Errorf("zipヘッダの作成に失敗しました (%s): %w", path, err)
+		}
+		header.Name = filepath.ToSlash(filepath.Join(rootName, relPath))
+		if info.IsDir() {
+			header.Name += "/"
+			_, err := zw.CreateHeader(header)
+			return err
+		}
+		header.Method = zip.Deflate
+
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return fmt.Errorf("zipヘッダの書き込みに失敗しました (%s): %w", header.Name, err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("ファイルを開くことができませんでした (%s): %w", path, err)
+		}
+		defer f.Close()
+		if _, err := io.Copy(w, f); err != nil {
+			return fmt.Errorf("ファイルのコピーに失敗しました (%s): %w", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		zw.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("zipの終了処理に失敗しました: %w", err)
+	}
+	return file.Close()
+}
+
+// extractArchive はバックアップアーカイブ (.tar.gz / .tar.zst) を destDir に展開します。
+func extractArchive(archivePath, destDir string) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("アーカイブ '%s' を開くことができませんでした: %w", archivePath, err)
+	}
+	defer file.Close()
+
+	var r io.Reader
+	switch {
+	case strings.HasSuffix(archivePath, ".tar.zst"):
+		zr, err := zstd.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("zstdリーダーの作成に失敗しました: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	case strings.HasSuffix(archivePath, ".tar.gz"), strings.HasSuffix(archivePath, ".tgz"):
+		gr, err := pgzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("gzipリーダーの作成に失敗しました: %w", err)
+		}
+		defer gr.Close()
+		r = gr
+	default:
+		return fmt.Errorf("未対応のアーカイブ形式です: %s", archivePath)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("tarアーカイブの読み込みに失敗しました: %w", err)
+		}
+
+		target, err := safeJoin(destDir, header.Name)
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("ディレクトリ '%s' の作成に失敗しました: %w", target, err)
+			}
+		case tar.TypeReg:
+			if err := writeFileFrom(tr, target, os.FileMode(header.Mode).Perm()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeFileFrom は r の内容を target に書き出します。
+func writeFileFrom(r io.Reader, target string, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("ディレクトリ '%s' の作成に失敗しました: %w", filepath.Dir(target), err)
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("ファイルを作成できませんでした (%s): %w", target, err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return fmt.Errorf("ファイルの展開に失敗しました (%s): %w", target, err)
+	}
+	return out.Close()
+}
+
+// safeJoin はアーカイブ内のパスを destDir 配下のパスに変換します。
+// destDir の外を指すパス (../ など) はエラーにします。
+func safeJoin(destDir, name string) (string, error) {
+	target := filepath.Join(destDir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(destDir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("アーカイブに不正なパスが含まれています: %s", name)
+	}
+	return target, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// makeWorlds は level.dat を含むワールドのディレクトリを作成する
+func makeWorlds(t *testing.T, root string, names ...string) []string {
+	t.Helper()
+	var dirs []string
+	for _, name := range names {
+		dir := filepath.Join(root, name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "level.dat"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+func TestExportLevelName(t *testing.T) {
+	tests := []struct {
+		name       string
+		worlds     []string
+		properties string // 空の場合はバックアップに server.properties を含めない
+		worldName  string
+		want       string // 空の場合はエラー
+	}{
+		{"single world", []string{"world", "world_nether", "world_the_end"}, "", "", "world"},
+		{"server.properties", []string{"lobby", "survival", "survival_nether"}, "level-name=survival\n", "", "survival"},
+		{"-name picks the world", []string{"lobby", "survival", "survival_nether"}, "", "survival", "survival"},
+		{"ambiguous", []string{"lobby", "survival", "survival_nether"}, "", "", ""},
+		{"-name is only the output name", []string{"lobby", "survival"}, "", "my_world", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sourceDir := t.TempDir()
+			dirs := makeWorlds(t, sourceDir, tt.worlds...)
+			if tt.properties != "" {
+				if err := os.WriteFile(filepath.Join(sourceDir, serverPropertiesFileName), []byte(tt.properties), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			levelName, err := backupLevelName(sourceDir, dirs, tt.worldName)
+			if err != nil {
+				t.Fatalf("backupLevelName: %v", err)
+			}
+			world, err := findServerWorld(dirs, levelName)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("findServerWorld = %s, want an error", world.Overworld)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("findServerWorld: %v", err)
+			}
+			if got := filepath.Base(world.Overworld); got != tt.want {
+				t.Errorf("overworld = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"common/rcon"
+)
+
+// pauseWorldSaving はサーバーが起動中であれば自動保存を止め、ワールドをディスクに書き出させます。
+// 稼働中のサーバーのバックアップで、書き込み途中のリージョンファイルをアーカイブしないために使います。
+// 戻り値の関数で自動保存を再開します。環境変数 RCON_PASSWORD_FILE が未設定の場合は何もしません。
+func pauseWorldSaving(ctx context.Context) (resume func()) {
+	noop := func() {}
+
+	passwordFile := os.Getenv("RCON_PASSWORD_FILE")
+	if passwordFile == "" {
+		return noop
+	}
+	client, err := rcon.NewClientFromFile(rconAddr(), passwordFile)
+	if err != nil {
+		log.Printf("警告: RCONクライアントを作成できないため、自動保存を止めずにバックアップします: %v", err)
+		return noop
+	}
+
+	saveCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	if _, err := client.Execute(saveCtx, "save-off"); err != nil {
+		client.Close()
+		if errors.Is(err, rcon.ErrConnectionRefused) {
+			// ExecStop から実行された場合など、サーバーが停止していれば止める必要はない
+			log.Println("情報: サーバーは停止しています。自動保存の制御は行いません。")
+		} else {
+			log.Printf("警告: 自動保存を停止できませんでした: %v", err)
+		}
+		return noop
+	}
+	// flush を付けるとディスクへの書き込みが終わるまで応答が返らない
+	if _, err := client.Execute(saveCtx, "save-all flush"); err != nil {
+		log.Printf("警告: ワールドの書き出しに失敗しました: %v", err)
+	} else {
+		log.Println("自動保存を停止し、ワールドをディスクに書き出しました。")
+	}
+
+	return func() {
+		defer client.Close()
+		resumeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if _, err := client.Execute(resumeCtx, "save-on"); err != nil {
+			log.Printf("警告: 自動保存を再開できませんでした。手動で save-on を実行してください: %v", err)
+			return
+		}
+		log.Println("自動保存を再開しました。")
+	}
+}
+
+// rconAddr は環境変数 RCON_HOST と RCON_PORT からRCONの接続先を返します。
+func rconAddr() string {
+	host := os.Getenv("RCON_HOST")
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	port := os.Getenv("RCON_PORT")
+	if port == "" {
+		port = "25575"
+	}
+	return host + ":" + port
+}
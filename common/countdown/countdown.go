@@ -0,0 +1,197 @@
+// Package countdown はサーバー停止までのカウントダウンをゲーム内に表示します。
+//
+// 期限の何秒前に表示するかを指定すると、その時刻に tellraw でチャットに、
+// 残りわずかになったら title で画面中央にも表示します。期限には停止理由を表示します。
+package countdown
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Reason はサーバーを停止する理由
+type Reason string
+
+const (
+	ReasonSpotInterruption Reason = "spot-interruption"
+	ReasonIdle             Reason = "idle"
+)
+
+// DefaultPoints はデフォルトの表示タイミング (期限の何秒前か)
+var DefaultPoints = []time.Duration{90 * time.Second, 60 * time.Second, 30 * time.Second, 10 * time.Second}
+
+// 残りがこの時間以下になったら画面中央にもタイトルを表示する
+const titleThreshold = 10 * time.Second
+
+// 開始時に残り時間を表示するのは、次のタイミングまでこの時間以上ある場合のみ
+const minAnnounceGap = 5 * time.Second
+
+// Commander はRCONなどでサーバーにコマンドを送るもの (*rcon.Client が満たす)
+type Commander interface {
+	Execute(ctx context.Context, command string) (string, error)
+}
+
+// Broadcaster はカウントダウンを表示する
+type Broadcaster struct {
+	Commander Commander
+	// 表示するタイミング (期限の何秒前か)。空の場合は DefaultPoints
+	Points []time.Duration
+	// メッセージの言語 ("ja" または "en")。未対応の言語は "ja" として扱う
+	Language string
+	Reason   Reason
+	// Check は各メッセージの前に呼ばれ、エラーを返すとカウントダウンを中止する (nil可)
+	// アイドル停止中にプレイヤーが戻ってきた場合などに使う
+	Check func(ctx context.Context) error
+	// Logf はログの出力先 (nil可)
+	Logf func(format string, args ...any)
+}
+
+// ParsePoints は "90s,60s,30s,10s" や "90,60,30,10" (秒) 形式の文字列を表示タイミングに変換する
+func ParsePoints(s string) ([]time.Duration, error) {
+	var points []time.Duration
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		d, err := time.ParseDuration(field)
+		if err != nil {
+			// 単位のない数値は秒とみなす
+			d, err = time.ParseDuration(field + "s")
+			if err != nil {
+				return nil, fmt.Errorf("invalid countdown point %q", field)
+			}
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("countdown point must be positive: %q", field)
+		}
+		points = append(points, d)
+	}
+	return points, nil
+}
+
+// Run は deadline までカウントダウンを表示し、期限に停止理由を表示してから戻る
+// 開始時点で最初のタイミングを過ぎている場合は、残り時間をすぐに1度表示する
+func (b *Broadcaster) Run(ctx context.Context, deadline time.Time) error {
+	points := append([]time.Duration(nil), b.Points...)
+	if len(points) == 0 {
+		points = DefaultPoints
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i] > points[j] })
+	msgs := messagesFor(b.Language)
+
+	// 既に過ぎたタイミングは飛ばし、代わりに現在の残り時間を表示する
+	// ただし次のタイミングがすぐ来る場合は、続けて表示されないよう省略する
+	left := time.Until(deadline)
+	if len(points) > 0 && left < points[0] && left > 0 {
+		next := time.Duration(0)
+		for _, point := range points {
+			if point <= left {
+				next = point
+				break
+			}
+		}
+		if left-next >= minAnnounceGap {
+			if err := b.announce(ctx, msgs, left.Round(time.Second)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, point := range points {
+		at := deadline.Add(-point)
+		if time.Until(at) < 0 {
+			continue
+		}
+		if err := sleepUntil(ctx, at); err != nil {
+			return err
+		}
+		if err := b.announce(ctx, msgs, point); err != nil {
+			return err
+		}
+	}
+
+	if err := sleepUntil(ctx, deadline); err != nil {
+		return err
+	}
+	if b.Check != nil {
+		if err := b.Check(ctx); err != nil {
+			return err
+		}
+	}
+	final := msgs.reason(b.Reason)
+	b.logf("Countdown finished: %s", final)
+	if err := b.tellraw(ctx, final, "red"); err != nil {
+		return err
+	}
+	return b.title(ctx, msgs.stoppingTitle, final)
+}
+
+func (b *Broadcaster) announce(ctx context.Context, msgs messages, left time.Duration) error {
+	if b.Check != nil {
+		if err := b.Check(ctx); err != nil {
+			return err
+		}
+	}
+	text := fmt.Sprintf(msgs.countdown, msgs.duration(left))
+	b.logf("Countdown: %s", text)
+	if err := b.tellraw(ctx, text, "gold"); err != nil {
+		return err
+	}
+	if left <= titleThreshold {
+		return b.title(ctx, msgs.duration(left), msgs.reason(b.Reason))
+	}
+	return nil
+}
+
+// tellraw は全員のチャット欄にメッセージを表示する
+func (b *Broadcaster) tellraw(ctx context.Context, text, color string) error {
+	component, err := json.Marshal(map[string]any{"text": "[Server] " + text, "color": color})
+	if err != nil {
+		return err
+	}
+	_, err = b.Commander.Execute(ctx, "tellraw @a "+string(component))
+	return err
+}
+
+// title は全員の画面中央にタイトルとサブタイトルを表示する
+func (b *Broadcaster) title(ctx context.Context, title, subtitle string) error {
+	sub, err := json.Marshal(map[string]any{"text": subtitle, "color": "yellow"})
+	if err != nil {
+		return err
+	}
+	if _, err := b.Commander.Execute(ctx, "title @a subtitle "+string(sub)); err != nil {
+		return err
+	}
+	main, err := json.Marshal(map[string]any{"text": title, "color": "red", "bold": true})
+	if err != nil {
+		return err
+	}
+	_, err = b.Commander.Execute(ctx, "title @a title "+string(main))
+	return err
+}
+
+func (b *Broadcaster) logf(format string, args ...any) {
+	if b.Logf != nil {
+		b.Logf(format, args...)
+	}
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,74 @@
+package countdown
+
+import (
+	"fmt"
+	"time"
+)
+
+// messages は言語ごとのメッセージのテンプレート
+type messages struct {
+	countdown     string // 残り時間の表示 (%s に残り時間が入る)
+	stoppingTitle string // 期限に画面中央に表示するタイトル
+	reasons       map[Reason]string
+	defaultReason string
+	// duration は残り時間を表示用の文字列にする
+	duration func(d time.Duration) string
+}
+
+var catalog = map[string]messages{
+	"ja": {
+		countdown:     "サーバーはあと%sで停止します。安全な場所に移動してください。",
+		stoppingTitle: "サーバー停止",
+		reasons: map[Reason]string{
+			ReasonSpotInterruption: "スポットインスタンスの中断のため、サーバーを停止します。",
+			ReasonIdle:             "プレイヤーがいない状態が続いたため、サーバーを停止します。",
+		},
+		defaultReason: "サーバーを停止します。",
+		duration: func(d time.Duration) string {
+			s := int(d.Seconds())
+			if s >= 60 && s%60 == 0 {
+				return fmt.Sprintf("%d分", s/60)
+			}
+			if s >= 60 {
+				return fmt.Sprintf("%d分%d秒", s/60, s%60)
+			}
+			return fmt.Sprintf("%d秒", s)
+		},
+	},
+	"en": {
+		countdown:     "The server will stop in %s. Please move to a safe place.",
+		stoppingTitle: "Server stopping",
+		reasons: map[Reason]string{
+			ReasonSpotInterruption: "The server is stopping because the spot instance is being interrupted.",
+			ReasonIdle:             "The server is stopping because no players have been online for a while.",
+		},
+		defaultReason: "The server is stopping.",
+		duration: func(d time.Duration) string {
+			s := int(d.Seconds())
+			if s == 1 {
+				return "1 second"
+			}
+			if s >= 60 && s%60 == 0 {
+				if s == 60 {
+					return "1 minute"
+				}
+				return fmt.Sprintf("%d minutes", s/60)
+			}
+			return fmt.Sprintf("%d seconds", s)
+		},
+	},
+}
+
+func messagesFor(language string) messages {
+	if msgs, ok := catalog[language]; ok {
+		return msgs
+	}
+	return catalog["ja"]
+}
+
+func (m messages) reason(r Reason) string {
+	if text, ok := m.reasons[r]; ok {
+		return text
+	}
+	return m.defaultReason
+}
@@ -0,0 +1,69 @@
+// Package failover はスポットインスタンスが中断されたときに、spot-handler から
+// 置き換えのコントローラーへ送るイベントを定義します。
+//
+// spot-handler はシャットダウン処理の最後に InterruptedEvent をSQSに送り、
+// コントローラーはそれを受け取って置き換えのインスタンスを起動します。
+package failover
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// EventInterrupted はスポットインスタンスの中断を知らせるイベントの種類
+const EventInterrupted = "interrupted"
+
+// InterruptedEvent は中断されたインスタンスの情報
+type InterruptedEvent struct {
+	// イベントの種類 (常に "interrupted")
+	Type             string `json:"type"`
+	InstanceID       string `json:"instanceId"`
+	AvailabilityZone string `json:"availabilityZone,omitempty"`
+	InstanceType     string `json:"instanceType,omitempty"`
+	// 中断のアクション (terminate, stop, hibernate) と時刻
+	Action           string    `json:"action"`
+	InterruptionTime time.Time `json:"interruptionTime"`
+	// 中断前に取った最新のバックアップ (上書きされない日時付きのキー)。不明な場合はnil
+	LatestBackup *BackupLocation `json:"latestBackup,omitempty"`
+	// シャットダウン処理で失敗したステップがあったか
+	ShutdownFailed bool      `json:"shutdownFailed"`
+	PublishedAt    time.Time `json:"publishedAt"`
+}
+
+// BackupLocation はS3に保存したバックアップの場所
+type BackupLocation struct {
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"createdAt,omitzero"`
+}
+
+// URI は s3://bucket/key 形式の場所を返す
+func (b *BackupLocation) URI() string {
+	return "s3://" + b.Bucket + "/" + b.Key
+}
+
+// UnknownEventError は別の種類のメッセージを受け取ったことを示す
+type UnknownEventError struct {
+	Type string
+}
+
+func (e *UnknownEventError) Error() string {
+	return fmt.Sprintf("failover: unknown event type %q", e.Type)
+}
+
+// Parse はメッセージの本文をイベントとして読み込む
+// 中断イベント以外のメッセージの場合は *UnknownEventError を返す
+func Parse(body []byte) (*InterruptedEvent, error) {
+	var event InterruptedEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("failover: failed to decode event: %w", err)
+	}
+	if event.Type != EventInterrupted {
+		return nil, &UnknownEventError{Type: event.Type}
+	}
+	if event.InstanceID == "" {
+		return nil, fmt.Errorf("failover: event has no instanceId")
+	}
+	return &event, nil
+}
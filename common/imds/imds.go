@@ -0,0 +1,296 @@
+// Package imds はEC2インスタンスメタデータサービス (IMDS) のクライアントです。
+//
+// IMDSv2のトークンはTTLが切れる少し前まで使い回し、401が返ったときは取り直します。
+// ネットワークエラーと5xxは指数バックオフでリトライします。
+// トークンを取得できない環境 (IMDSv1のみ) ではトークンなしでリクエストします。
+package imds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBaseURL はEC2上のIMDSのURL
+	DefaultBaseURL = "http://169.254.169.254"
+
+	tokenPath      = "/latest/api/token"
+	tokenHeader    = "X-aws-ec2-metadata-token"
+	tokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+
+	defaultTokenTTL     = 6 * time.Hour
+	defaultRefreshAhead = time.Minute
+	defaultTimeout      = 2 * time.Second
+	defaultRetries      = 2
+	defaultBackoff      = 200 * time.Millisecond
+)
+
+// ErrNotFound はメタデータが存在しない (404) ことを示す
+// スポットの中断通知などは、通知がないときに404を返す
+var ErrNotFound = errors.New("imds: not found")
+
+// StatusError は予期しないHTTPステータスが返ったことを示す
+type StatusError struct {
+	Path       string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("imds: unexpected status code %d for %s", e.StatusCode, e.Path)
+}
+
+// Client はIMDSクライアント。複数のgoroutineから同時に使っても安全
+type Client struct {
+	baseURL      string
+	httpClient   *http.Client
+	tokenTTL     time.Duration
+	refreshAhead time.Duration
+	retries      int
+	backoff      time.Duration
+
+	hooks Hooks
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// Hooks はリクエストの計測に使うコールバック。nilの項目は呼ばれない
+type Hooks struct {
+	// Request はメタデータのリクエストごとに呼ばれる。ネットワークエラーの場合 status は0
+	Request func(path string, status int, duration time.Duration)
+	// TokenRefresh はIMDSv2トークンを取得するたびに呼ばれる
+	TokenRefresh func(err error)
+}
+
+// Option はクライアントの設定を変更する
+type Option func(*Client)
+
+// WithBaseURL はIMDSのURLを変更する (ローカルのエミュレーターでのテスト用)
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		if baseURL != "" {
+			c.baseURL = strings.TrimRight(baseURL, "/")
+		}
+	}
+}
+
+// WithTokenTTL はIMDSv2トークンの有効期間を変更する (最大6時間)
+func WithTokenTTL(ttl time.Duration) Option {
+	return func(c *Client) {
+		if ttl > 0 {
+			c.tokenTTL = ttl
+		}
+	}
+}
+
+// WithRetries はネットワークエラーと5xxのリトライ回数と、最初の待ち時間を変更する
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		if retries >= 0 {
+			c.retries = retries
+		}
+		if backoff > 0 {
+			c.backoff = backoff
+		}
+	}
+}
+
+// WithTimeout は1回のリクエストのタイムアウトを変更する
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		if timeout > 0 {
+			c.httpClient = &http.Client{Timeout: timeout}
+		}
+	}
+}
+
+// WithHooks はメトリクス用のコールバックを設定する
+func WithHooks(hooks Hooks) Option {
+	return func(c *Client) {
+		c.hooks = hooks
+	}
+}
+
+// New はクライアントを作成する
+func New(opts ...Option) *Client {
+	c := &Client{
+		baseURL:      DefaultBaseURL,
+		httpClient:   &http.Client{Timeout: defaultTimeout},
+		tokenTTL:     defaultTokenTTL,
+		refreshAhead: defaultRefreshAhead,
+		retries:      defaultRetries,
+		backoff:      defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	// TTLが短い場合でも、期限切れ直前まで使わないようにする
+	if c.refreshAhead > c.tokenTTL/2 {
+		c.refreshAhead = c.tokenTTL / 2
+	}
+	return c
+}
+
+// BaseURL はIMDSのURLを返す
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// Get はメタデータのパス (例: /latest/meta-data/instance-id) の内容を返す
+// 404の場合は ErrNotFound を返す
+func (c *Client) Get(ctx context.Context, path string) ([]byte, error) {
+	var lastErr error
+	wait := c.backoff
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+
+		body, err := c.getOnce(ctx, path)
+		if err == nil || !retryable(err) {
+			return body, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (c *Client) getOnce(ctx context.Context, path string) ([]byte, error) {
+	status, body, err := c.do(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusUnauthorized {
+		// トークンが失効していた可能性があるので、取り直して1度だけやり直す
+		c.invalidateToken()
+		status, body, err = c.do(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case status == http.StatusOK:
+		return body, nil
+	case status == http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, path)
+	default:
+		return nil, &StatusError{Path: path, StatusCode: status}
+	}
+}
+
+func (c *Client) do(ctx context.Context, path string) (int, []byte, error) {
+	token, err := c.getToken(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("imds: failed to create request: %w", err)
+	}
+	if token != "" {
+		req.Header.Set(tokenHeader, token)
+	}
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.observeRequest(path, 0, start)
+		return 0, nil, fmt.Errorf("imds: failed to get %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	c.observeRequest(path, resp.StatusCode, start)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, fmt.Errorf("imds: failed to read %s: %w", path, err)
+	}
+	return resp.StatusCode, body, nil
+}
+
+func (c *Client) observeRequest(path string, status int, start time.Time) {
+	if c.hooks.Request != nil {
+		c.hooks.Request(path, status, time.Since(start))
+	}
+}
+
+// getToken はキャッシュしたIMDSv2トークンを返す。期限が近い場合は取り直す
+// トークンを発行できない環境 (IMDSv1のみ) では空文字列を返す
+func (c *Client) getToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.tokenExpiry.Add(-c.refreshAhead)) {
+		return c.token, nil
+	}
+	token, err := c.fetchToken(ctx)
+	if c.hooks.TokenRefresh != nil {
+		c.hooks.TokenRefresh(err)
+	}
+	return token, err
+}
+
+// fetchToken はIMDSv2トークンを取得してキャッシュする。呼び出し側で c.mu をロックしておくこと
+func (c *Client) fetchToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.baseURL+tokenPath, nil)
+	if err != nil {
+		return "", fmt.Errorf("imds: failed to create token request: %w", err)
+	}
+	req.Header.Set(tokenTTLHeader, strconv.Itoa(int(c.tokenTTL.Seconds())))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("imds: failed to get token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusForbidden, resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusMethodNotAllowed:
+		// IMDSv2が無効な環境ではトークンなしで続ける
+		return "", nil
+	default:
+		return "", &StatusError{Path: tokenPath, StatusCode: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("imds: failed to read token: %w", err)
+	}
+	c.token = strings.TrimSpace(string(body))
+	c.tokenExpiry = time.Now().Add(c.tokenTTL)
+	return c.token, nil
+}
+
+func (c *Client) invalidateToken() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = ""
+	c.tokenExpiry = time.Time{}
+}
+
+// retryable はネットワークエラーと5xxのときにtrueを返す
+func retryable(err error) bool {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package imds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// メタデータのパス
+const (
+	instanceIDPath       = "/latest/meta-data/instance-id"
+	instanceTypePath     = "/latest/meta-data/instance-type"
+	availabilityZonePath = "/latest/meta-data/placement/availability-zone"
+	publicIPv4Path       = "/latest/meta-data/public-ipv4"
+	spotActionPath       = "/latest/meta-data/spot/instance-action"
+	rebalancePath        = "/latest/meta-data/events/recommendations/rebalance"
+	scheduledEventsPath  = "/latest/meta-data/events/maintenance/scheduled"
+)
+
+// SpotAction は spot/instance-action が返す中断通知
+// 例: {"action": "terminate", "time": "2017-09-18T08:22:00Z"}
+type SpotAction struct {
+	Action string    `json:"action"`
+	Time   time.Time `json:"time"`
+}
+
+// Rebalance は events/recommendations/rebalance が返すリバランス推奨
+// 例: {"noticeTime": "2020-10-27T08:22:00Z"}
+type Rebalance struct {
+	NoticeTime time.Time `json:"noticeTime"`
+}
+
+// ScheduledEvent は events/maintenance/scheduled が返すメンテナンスイベント
+// NotBefore などの時刻は "21 Jan 2019 09:00:43 GMT" 形式の文字列で返る
+type ScheduledEvent struct {
+	Code              string `json:"Code"`
+	Description       string `json:"Description"`
+	EventID           string `json:"EventId"`
+	NotBefore         string `json:"NotBefore"`
+	NotAfter          string `json:"NotAfter"`
+	NotBeforeDeadline string `json:"NotBeforeDeadline"`
+	State             string `json:"State"`
+}
+
+// scheduledEventTimeLayout はメンテナンスイベントの時刻の形式
+const scheduledEventTimeLayout = "2 Jan 2006 15:04:05 MST"
+
+// ParseEventTime はメンテナンスイベントの時刻をパースする。空の場合はゼロ値を返す
+func ParseEventTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(scheduledEventTimeLayout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("imds: invalid event time %q: %w", s, err)
+	}
+	return t, nil
+}
+
+// InstanceID はインスタンスIDを返す
+func (c *Client) InstanceID(ctx context.Context) (string, error) {
+	return c.getString(ctx, instanceIDPath)
+}
+
+// InstanceType はインスタンスタイプを返す
+func (c *Client) InstanceType(ctx context.Context) (string, error) {
+	return c.getString(ctx, instanceTypePath)
+}
+
+// AvailabilityZone はアベイラビリティーゾーンを返す
+func (c *Client) AvailabilityZone(ctx context.Context) (string, error) {
+	return c.getString(ctx, availabilityZonePath)
+}
+
+// PublicIPv4 はパブリックIPv4アドレスを返す。割り当てられていない場合は ErrNotFound
+func (c *Client) PublicIPv4(ctx context.Context) (string, error) {
+	return c.getString(ctx, publicIPv4Path)
+}
+
+// SpotInstanceAction はスポットの中断通知を返す。通知がない場合は nil を返す
+func (c *Client) SpotInstanceAction(ctx context.Context) (*SpotAction, error) {
+	var action SpotAction
+	found, err := c.getJSON(ctx, spotActionPath, &action)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &action, nil
+}
+
+// RebalanceRecommendation はリバランス推奨を返す。推奨がない場合は nil を返す
+func (c *Client) RebalanceRecommendation(ctx context.Context) (*Rebalance, error) {
+	var rec Rebalance
+	found, err := c.getJSON(ctx, rebalancePath, &rec)
+	if !found || err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// ScheduledEvents は予定されているメンテナンスイベントを返す。ない場合は空のスライス
+func (c *Client) ScheduledEvents(ctx context.Context) ([]ScheduledEvent, error) {
+	var events []ScheduledEvent
+	if _, err := c.getJSON(ctx, scheduledEventsPath, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (c *Client) getString(ctx context.Context, path string) (string, error) {
+	body, err := c.Get(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// getJSON はJSONをデコードする。404の場合は found=false でエラーは返さない
+func (c *Client) getJSON(ctx context.Context, path string, v any) (found bool, err error) {
+	body, err := c.Get(ctx, path)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return false, nil
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return false, fmt.Errorf("imds: failed to decode %s: %w", path, err)
+	}
+	return true, nil
+}
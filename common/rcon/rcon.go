@@ -0,0 +1,284 @@
+// Package rcon はMinecraftサーバーのRCONクライアントです。
+//
+// 接続は使い回し、切断されていた場合は次の Execute で自動的に再接続します。
+// 4096バイトを超える応答は複数のパケットに分割されて返ってくるため、
+// コマンドの直後に空のパケットを送り、その応答が届くまでを1つの応答として結合します。
+package rcon
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// パケットの種別
+const (
+	typeResponseValue = 0
+	typeExecCommand   = 2
+	typeAuthResponse  = 2
+	typeAuth          = 3
+)
+
+const (
+	// サーバーに送るパケットの本文の上限 (Minecraftの制限)
+	maxCommandLength = 1446
+	// サーバーから受け取るパケットの上限 (本文4096バイト + ヘッダ)
+	maxResponsePacketLength = 4096 + 10
+
+	defaultDialTimeout = 5 * time.Second
+	defaultTimeout     = 10 * time.Second
+)
+
+// 呼び出し側が errors.Is で判別できるエラー
+var (
+	// ErrConnectionRefused はサーバーが起動していない (接続を拒否された) ことを示す
+	ErrConnectionRefused = errors.New("rcon: connection refused")
+	// ErrAuthFailed はパスワードが間違っていることを示す
+	ErrAuthFailed = errors.New("rcon: authentication failed")
+	// ErrTimeout は接続または応答がタイムアウトしたことを示す
+	ErrTimeout = errors.New("rcon: timeout")
+)
+
+// Client はRCONクライアント。複数のgoroutineから同時に使っても安全
+type Client struct {
+	addr        string
+	password    string
+	dialTimeout time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	nextID int32
+}
+
+// NewClient はクライアントを作成する。接続は最初の Execute で行う
+func NewClient(addr, password string) *Client {
+	return &Client{
+		addr:        addr,
+		password:    password,
+		dialTimeout: defaultDialTimeout,
+	}
+}
+
+// NewClientFromFile はパスワードをファイルから読み込んでクライアントを作成する
+func NewClientFromFile(addr, passwordFile string) (*Client, error) {
+	password, err := ReadPasswordFile(passwordFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(addr, password), nil
+}
+
+// ReadPasswordFile はRCONパスワードをファイルから読み込む
+// パスワードをコマンドライン引数や設定ファイルに載せないため、ファイルにはパスワードのみを書いておく
+func ReadPasswordFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("rcon: failed to read password file: %w", err)
+	}
+	password := strings.TrimSpace(string(data))
+	if password == "" {
+		return "", fmt.Errorf("rcon: password file %s is empty", path)
+	}
+	return password, nil
+}
+
+// Execute はコマンドを実行して応答を返す
+// ctx にデッドラインがない場合は10秒でタイムアウトする
+func (c *Client) Execute(ctx context.Context, command string) (string, error) {
+	if len(command) > maxCommandLength {
+		return "", fmt.Errorf("rcon: command is too long (%d > %d bytes)", len(command), maxCommandLength)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reused := c.conn != nil
+	resp, err := c.execute(ctx, command)
+	if err != nil && reused && ctx.Err() == nil && !errors.Is(err, ErrAuthFailed) {
+		// 使い回した接続がサーバー側で切られていた可能性があるので、一度だけ接続し直す
+		resp, err = c.execute(ctx, command)
+	}
+	return resp, err
+}
+
+// Close は接続を閉じる
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closeConn()
+}
+
+func (c *Client) closeConn() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+func (c *Client) execute(ctx context.Context, command string) (string, error) {
+	if c.conn == nil {
+		if err := c.connect(ctx); err != nil {
+			return "", err
+		}
+	}
+
+	stop := c.watchContext(ctx)
+	defer stop()
+
+	resp, err := c.roundTrip(command)
+	if err != nil {
+		c.closeConn()
+		return "", classify(ctx, fmt.Errorf("rcon: failed to execute %q: %w", command, err))
+	}
+	return resp, nil
+}
+
+// connect は接続して認証する
+func (c *Client) connect(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: c.dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return classify(ctx, fmt.Errorf("rcon: failed to connect to %s: %w", c.addr, err))
+	}
+	c.conn = conn
+
+	stop := c.watchContext(ctx)
+	defer stop()
+
+	id := c.newID()
+	if err := writePacket(conn, id, typeAuth, c.password); err != nil {
+		c.closeConn()
+		return classify(ctx, fmt.Errorf("rcon: failed to send auth: %w", err))
+	}
+	for {
+		respID, respType, _, err := readPacket(conn)
+		if err != nil {
+			c.closeConn()
+			return classify(ctx, fmt.Errorf("rcon: failed to read auth response: %w", err))
+		}
+		// Source系のサーバーは認証応答の前に空のRESPONSE_VALUEを返すことがあるので読み飛ばす
+		if respType != typeAuthResponse {
+			continue
+		}
+		if respID == -1 || respID != id {
+			c.closeConn()
+			return ErrAuthFailed
+		}
+		return nil
+	}
+}
+
+// roundTrip はコマンドを送り、分割された応答を結合して返す
+func (c *Client) roundTrip(command string) (string, error) {
+	cmdID := c.newID()
+	endID := c.newID()
+	if err := writePacket(c.conn, cmdID, typeExecCommand, command); err != nil {
+		return "", err
+	}
+	// サーバーはパケットを順番に処理するため、この空パケットへの応答が届けばコマンドの応答は全て届いている
+	if err := writePacket(c.conn, endID, typeResponseValue, ""); err != nil {
+		return "", err
+	}
+
+	var resp strings.Builder
+	received := false
+	for {
+		id, _, body, err := readPacket(c.conn)
+		if err != nil {
+			if received && (errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)) {
+				// stop などサーバーが応答直後に接続を閉じるコマンドでは、終端の応答は返ってこない
+				c.closeConn()
+				return resp.String(), nil
+			}
+			return "", err
+		}
+		switch id {
+		case cmdID:
+			received = true
+			resp.WriteString(body)
+		case endID:
+			return resp.String(), nil
+		}
+	}
+}
+
+// watchContext はctxのデッドラインを接続に設定し、キャンセルされたら読み書きを中断させる
+func (c *Client) watchContext(ctx context.Context) (stop func()) {
+	conn := c.conn
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultTimeout)
+	}
+	conn.SetDeadline(deadline)
+	cancelStop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	return func() {
+		cancelStop()
+		conn.SetDeadline(time.Time{})
+	}
+}
+
+func (c *Client) newID() int32 {
+	c.nextID++
+	if c.nextID <= 0 {
+		c.nextID = 1
+	}
+	return c.nextID
+}
+
+// classify はネットワークエラーを ErrConnectionRefused や ErrTimeout と判別できるようにする
+func classify(ctx context.Context, err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return fmt.Errorf("%w: %w", ErrConnectionRefused, err)
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout(),
+		ctx.Err() != nil:
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
+}
+
+func writePacket(w io.Writer, id, packetType int32, body string) error {
+	var buf bytes.Buffer
+	// 長さ = ID(4) + 種別(4) + 本文 + 終端のnull 2バイト
+	binary.Write(&buf, binary.LittleEndian, int32(4+4+len(body)+2))
+	binary.Write(&buf, binary.LittleEndian, id)
+	binary.Write(&buf, binary.LittleEndian, packetType)
+	buf.WriteString(body)
+	buf.Write([]byte{0, 0})
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func readPacket(r io.Reader) (id, packetType int32, body string, err error) {
+	var length int32
+	if err = binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return
+	}
+	if length < 10 || length > maxResponsePacketLength {
+		err = fmt.Errorf("invalid packet length: %d", length)
+		return
+	}
+	payload := make([]byte, length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	id = int32(binary.LittleEndian.Uint32(payload[0:4]))
+	packetType = int32(binary.LittleEndian.Uint32(payload[4:8]))
+	body = string(bytes.TrimRight(payload[8:length-2], "\x00"))
+	return
+}
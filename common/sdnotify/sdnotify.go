@@ -0,0 +1,91 @@
+// Package sdnotify は systemd の sd_notify プロトコルでサービスの状態を通知します。
+//
+// Type=notify のユニットでは起動完了 (READY=1) を、WatchdogSec を設定したユニットでは
+// 定期的な WATCHDOG=1 を送る必要があります。NOTIFY_SOCKET が設定されていない場合
+// (systemd の外で実行した場合など) は何もしません。
+package sdnotify
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 送信する状態
+const (
+	StateReady     = "READY=1"
+	StateReloading = "RELOADING=1"
+	StateStopping  = "STOPPING=1"
+	StateWatchdog  = "WATCHDOG=1"
+)
+
+// Notify は状態を NOTIFY_SOCKET に送る。複数の状態は改行で区切る
+// NOTIFY_SOCKET が設定されていない場合は何もせず false を返す
+func Notify(states ...string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// @ で始まる場合は抽象名前空間のソケット
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("sdnotify: failed to connect to %s: %w", socket, err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		return false, fmt.Errorf("sdnotify: failed to send: %w", err)
+	}
+	return true, nil
+}
+
+// Ready は起動が完了したことを通知する。status が空でなければ状態の説明も送る
+func Ready(status string) error {
+	states := []string{StateReady}
+	if status != "" {
+		states = append(states, Status(status))
+	}
+	_, err := Notify(states...)
+	return err
+}
+
+// SetStatus は systemctl status に表示する状態の説明を送る
+func SetStatus(status string) error {
+	_, err := Notify(Status(status))
+	return err
+}
+
+// Watchdog はウォッチドッグのタイマーをリセットする
+func Watchdog() error {
+	_, err := Notify(StateWatchdog)
+	return err
+}
+
+// Status は状態の説明 (STATUS=...) を返す。改行は送れないため空白に置き換える
+func Status(status string) string {
+	return "STATUS=" + strings.ReplaceAll(status, "\n", " ")
+}
+
+// ExtendTimeout は起動・停止のタイムアウトを d だけ延長するよう求める状態を返す
+func ExtendTimeout(d time.Duration) string {
+	return "EXTEND_TIMEOUT_USEC=" + strconv.FormatInt(d.Microseconds(), 10)
+}
+
+// WatchdogInterval はユニットの WatchdogSec を返す
+// ウォッチドッグが無効な場合や、このプロセス宛てでない場合は false を返す
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config はコントローラーの設定
+type Config struct {
+	// spot-handler が中断イベントを送るSQSキューのURL
+	QueueURL string `yaml:"queueUrl"`
+	Region   string `yaml:"region"`
+	// 置き換えのインスタンスを起動する起動テンプレート
+	LaunchTemplate LaunchTemplateConfig `yaml:"launchTemplate"`
+	// 置き換えに使うインスタンスタイプ (順に試す)。空の場合は中断されたインスタンスと同じタイプ
+	InstanceTypes []string `yaml:"instanceTypes"`
+	// アベイラビリティーゾーン → サブネットID。空の場合は起動テンプレートのサブネットを使う
+	Subnets map[string]string `yaml:"subnets"`
+	// 中断されたアベイラビリティーゾーンを後回しにするか
+	PreferOtherZones bool `yaml:"preferOtherZones"`
+	// スポットインスタンスとして起動するか (false の場合はオンデマンド)
+	Spot bool `yaml:"spot"`
+	// -fake で起動した場合の偽のEC2の設定
+	Fake FakeConfig `yaml:"fake"`
+}
+
+// LaunchTemplateConfig は起動テンプレートの指定
+type LaunchTemplateConfig struct {
+	ID      string `yaml:"id"`
+	Version string `yaml:"version"`
+}
+
+// FakeConfig はローカルでテストするための偽のEC2の設定
+type FakeConfig struct {
+	// 容量不足で起動に失敗させる組み合わせ ("インスタンスタイプ@アベイラビリティーゾーン")
+	Unavailable []string `yaml:"unavailable"`
+}
+
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	config := Config{
+		PreferOtherZones: true,
+		Spot:             true,
+	}
+	// 書き間違えたキーが黙って無視されないよう、知らないキーはエラーにする
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to unmarshal config yaml: %w", err)
+	}
+	if config.LaunchTemplate.ID == "" {
+		return nil, fmt.Errorf("launchTemplate.id is required")
+	}
+	if config.LaunchTemplate.Version == "" {
+		config.LaunchTemplate.Version = "$Latest"
+	}
+	return &config, nil
+}
+
+// zones はサブネットを設定したアベイラビリティーゾーンを名前順に返す
+func (c *Config) zones() []string {
+	zones := make([]string, 0, len(c.Subnets))
+	for zone := range c.Subnets {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	return zones
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// launchRequest は起動するインスタンスの指定
+type launchRequest struct {
+	candidate
+	Tags map[string]string
+}
+
+// instanceLauncher は置き換えのインスタンスを起動するもの
+type instanceLauncher interface {
+	// FindReplacement は instanceID の置き換えとして起動済みで、動いているインスタンスのIDを返す。なければ空文字列を返す
+	FindReplacement(ctx context.Context, instanceID string) (string, error)
+	// Launch はインスタンスを起動してIDを返す
+	Launch(ctx context.Context, req launchRequest) (string, error)
+}
+
+// ec2API はコントローラーが使うEC2のAPI (*ec2.Client が満たす)
+// ローカルでは fakeEC2 に差し替えてテストする
+type ec2API interface {
+	RunInstances(ctx context.Context, params *ec2.RunInstancesInput, optFns ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error)
+	DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+}
+
+// ec2Launcher は起動テンプレートからインスタンスを起動する
+type ec2Launcher struct {
+	client ec2API
+	config *Config
+}
+
+func (l *ec2Launcher) FindReplacement(ctx context.Context, instanceID string) (string, error) {
+	out, err := l.client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("tag:" + tagReplaces), Values: []string{instanceID}},
+			{Name: aws.String("instance-state-name"), Values: []string{"pending", "running"}},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, reservation := range out.Reservations {
+		for _, instance := range reservation.Instances {
+			return aws.ToString(instance.InstanceId), nil
+		}
+	}
+	return "", nil
+}
+
+func (l *ec2Launcher) Launch(ctx context.Context, req launchRequest) (string, error) {
+	input := &ec2.RunInstancesInput{
+		MinCount: aws.Int32(1),
+		MaxCount: aws.Int32(1),
+		LaunchTemplate: &types.LaunchTemplateSpecification{
+			LaunchTemplateId: aws.String(l.config.LaunchTemplate.ID),
+			Version:          aws.String(l.config.LaunchTemplate.Version),
+		},
+		InstanceType: types.InstanceType(req.InstanceType),
+	}
+	if req.SubnetID != "" {
+		input.SubnetId = aws.String(req.SubnetID)
+	}
+	if l.config.Spot {
+		input.InstanceMarketOptions = &types.InstanceMarketOptionsRequest{
+			MarketType: types.MarketTypeSpot,
+			SpotOptions: &types.SpotMarketOptions{
+				SpotInstanceType:             types.SpotInstanceTypeOneTime,
+				InstanceInterruptionBehavior: types.InstanceInterruptionBehaviorTerminate,
+			},
+		}
+	}
+	if len(req.Tags) > 0 {
+		spec := types.TagSpecification{ResourceType: types.ResourceTypeInstance}
+		for _, k := range sortedKeys(req.Tags) {
+			spec.Tags = append(spec.Tags, types.Tag{Key: aws.String(k), Value: aws.String(req.Tags[k])})
+		}
+		input.TagSpecifications = []types.TagSpecification{spec}
+	}
+
+	out, err := l.client.RunInstances(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	if len(out.Instances) == 0 {
+		return "", fmt.Errorf("RunInstances returned no instances")
+	}
+	return aws.ToString(out.Instances[0].InstanceId), nil
+}
+
+// fakeEC2 はローカルでテストするための偽のEC2
+// 起動したインスタンスをメモリに記録し、設定した組み合わせは容量不足で失敗させる
+type fakeEC2 struct {
+	unavailable []string
+	subnets     map[string]string // アベイラビリティーゾーン → サブネットID
+
+	mu        sync.Mutex
+	instances []types.Instance
+}
+
+func newFakeEC2(config *Config) *fakeEC2 {
+	return &fakeEC2{unavailable: config.Fake.Unavailable, subnets: config.Subnets}
+}
+
+// fakeCapacityError は容量不足のエラー (InsufficientInstanceCapacity) を模したもの
+type fakeCapacityError struct {
+	target string
+}
+
+func (e *fakeCapacityError) Error() string {
+	return fmt.Sprintf("InsufficientInstanceCapacity: insufficient capacity for %s (fake)", e.target)
+}
+
+func (f *fakeEC2) RunInstances(ctx context.Context, params *ec2.RunInstancesInput, optFns ...func(*ec2.Options)) (*ec2.RunInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	zone := ""
+	if params.SubnetId != nil {
+		zone = f.zoneOf(aws.ToString(params.SubnetId))
+	}
+	target := string(params.InstanceType)
+	if zone != "" {
+		target += "@" + zone
+	}
+	if slices.Contains(f.unavailable, target) || slices.Contains(f.unavailable, string(params.InstanceType)) {
+		return nil, &fakeCapacityError{target: target}
+	}
+
+	instance := types.Instance{
+		InstanceId:   aws.String(fmt.Sprintf("i-fake%012d", len(f.instances)+1)),
+		InstanceType: params.InstanceType,
+		SubnetId:     params.SubnetId,
+		State:        &types.InstanceState{Name: types.InstanceStateNameRunning},
+	}
+	for _, spec := range params.TagSpecifications {
+		instance.Tags = append(instance.Tags, spec.Tags...)
+	}
+	f.instances = append(f.instances, instance)
+	log.Printf("[fake ec2] RunInstances %s (launch template %s, spot=%t)", target,
+		aws.ToString(params.LaunchTemplate.LaunchTemplateId), params.InstanceMarketOptions != nil)
+	return &ec2.RunInstancesOutput{Instances: []types.Instance{instance}}, nil
+}
+
+func (f *fakeEC2) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var matched []types.Instance
+	for _, instance := range f.instances {
+		if fakeMatches(instance, params.Filters) {
+			matched = append(matched, instance)
+		}
+	}
+	out := &ec2.DescribeInstancesOutput{}
+	if len(matched) > 0 {
+		out.Reservations = []types.Reservation{{Instances: matched}}
+	}
+	return out, nil
+}
+
+// zoneOf はサブネットIDに対応するアベイラビリティーゾーンを返す (偽のEC2はサブネットの情報を持たないため設定から引く)
+func (f *fakeEC2) zoneOf(subnetID string) string {
+	for zone, id := range f.subnets {
+		if id == subnetID {
+			return zone
+		}
+	}
+	return ""
+}
+
+// fakeMatches はタグと状態のフィルターに一致するかを返す (FindReplacement が使うフィルターのみ対応)
+func fakeMatches(instance types.Instance, filters []types.Filter) bool {
+	for _, filter := range filters {
+		name := aws.ToString(filter.Name)
+		if name == "instance-state-name" {
+			if !slices.Contains(filter.Values, string(instance.State.Name)) {
+				return false
+			}
+		}
+		if key, ok := strings.CutPrefix(name, "tag:"); ok {
+			found := false
+			for _, tag := range instance.Tags {
+				if aws.ToString(tag.Key) == key && slices.Contains(filter.Values, aws.ToString(tag.Value)) {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
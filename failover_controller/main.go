@@ -0,0 +1,162 @@
+// failover_controller はスポットインスタンスが中断されたときに置き換えのインスタンスを起動するコントローラーです。
+//
+// spot-handler がシャットダウン処理の最後にSQSに送る中断イベント (common/failover) を受け取り、
+// 起動テンプレートから置き換えのインスタンスを起動します。置き換えるのは terminate の場合だけで、
+// 再開される stop/hibernate のイベントは記録だけして削除します。容量不足などで起動できない場合は、
+// 設定したインスタンスタイプとアベイラビリティーゾーンを順に試します。
+// 起動したインスタンスには、中断されたインスタンスのIDと復元に使う最新のバックアップの場所をタグで付けます。
+//
+// 使い方:
+//
+//	go run . -config controller.yaml
+//
+// ローカルでは -fake で偽のEC2を使い、-event で中断イベントのJSONファイルを1件だけ処理できます。
+//
+//	go run . -config controller.yaml -fake -event event.json
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"common/failover"
+	"common/sdnotify"
+)
+
+const (
+	// SQSのロングポーリングで待つ時間
+	receiveWaitSeconds = 20
+	// 1件の置き換えにかける時間
+	replaceTimeout = 2 * time.Minute
+	// 受信エラー時に待ち直すまでの時間
+	receiveRetryInterval = 10 * time.Second
+)
+
+func main() {
+	configPath := flag.String("config", "/etc/failover-controller/config.yaml", "Path to the configuration file")
+	fake := flag.Bool("fake", false, "Use an in-memory fake EC2 instead of the real API")
+	eventPath := flag.String("event", "", "Handle a single interrupted event from a JSON file instead of polling SQS")
+	flag.Parse()
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Fatal: Could not load config from %s. %v", *configPath, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var opts []func(*awsconfig.LoadOptions) error
+	if config.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(config.Region))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		log.Fatalf("Fatal: Could not load AWS SDK config. %v", err)
+	}
+
+	var client ec2API = ec2.NewFromConfig(awsCfg)
+	if *fake {
+		log.Println("Using the fake EC2. No instances will be launched.")
+		client = newFakeEC2(config)
+	}
+	r := &replacer{config: config, launcher: &ec2Launcher{client: client, config: config}}
+
+	if *eventPath != "" {
+		data, err := os.ReadFile(*eventPath)
+		if err != nil {
+			log.Fatalf("Fatal: Could not read event. %v", err)
+		}
+		event, err := failover.Parse(data)
+		if err != nil {
+			log.Fatalf("Fatal: %v", err)
+		}
+		if _, err := r.replace(ctx, event); err != nil {
+			log.Fatalf("Fatal: Could not replace %s. %v", event.InstanceID, err)
+		}
+		return
+	}
+
+	if config.QueueURL == "" {
+		log.Fatal("Fatal: queueUrl is required unless -event is given")
+	}
+	queue := sqs.NewFromConfig(awsCfg)
+	log.Printf("Waiting for interrupted events on %s", config.QueueURL)
+	if err := sdnotify.Ready("Waiting for interrupted events"); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+
+	for ctx.Err() == nil {
+		out, err := queue.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(config.QueueURL),
+			MaxNumberOfMessages: 1,
+			WaitTimeSeconds:     receiveWaitSeconds,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("Error receiving messages: %v. Retrying in %s.", err, receiveRetryInterval)
+			select {
+			case <-ctx.Done():
+			case <-time.After(receiveRetryInterval):
+			}
+			continue
+		}
+
+		for _, msg := range out.Messages {
+			if handleMessage(ctx, r, aws.ToString(msg.Body)) {
+				_, err := queue.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+					QueueUrl:      aws.String(config.QueueURL),
+					ReceiptHandle: msg.ReceiptHandle,
+				})
+				if err != nil {
+					log.Printf("Failed to delete message %s: %v", aws.ToString(msg.MessageId), err)
+				}
+			}
+		}
+	}
+	log.Println("Shutting down.")
+}
+
+// handleMessage はメッセージを処理し、キューから削除してよい場合にtrueを返す
+// 置き換えに失敗した場合は削除せず、可視性タイムアウトの後に受け取り直して再試行する
+func handleMessage(ctx context.Context, r *replacer, body string) bool {
+	event, err := failover.Parse([]byte(body))
+	var unknown *failover.UnknownEventError
+	switch {
+	case errors.As(err, &unknown):
+		// 同じキューを使う別のコンシューマー向けのメッセージは残しておく
+		log.Printf("Ignoring message of type %q.", unknown.Type)
+		return false
+	case err != nil:
+		// 読めないメッセージは再試行しても処理できない
+		log.Printf("Dropping invalid message: %v", err)
+		return true
+	}
+
+	log.Printf("Interrupted event received. Instance: %s, Type: %s, AZ: %s, Action: %s",
+		event.InstanceID, event.InstanceType, event.AvailabilityZone, event.Action)
+	if event.LatestBackup != nil {
+		log.Printf("Latest backup: %s", event.LatestBackup.URI())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, replaceTimeout)
+	defer cancel()
+	if _, err := r.replace(ctx, event); err != nil {
+		log.Printf("Could not replace %s: %v. Will retry when the message becomes visible again.", event.InstanceID, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"common/failover"
+)
+
+// 置き換えのインスタンスに付けるタグ
+const (
+	tagReplaces      = "makimaki:replaces"
+	tagRestoreBackup = "makimaki:restore-backup"
+)
+
+// 置き換えを起動する中断のアクション
+const actionTerminate = "terminate"
+
+// candidate は置き換えのインスタンスの起動候補
+type candidate struct {
+	InstanceType     string
+	AvailabilityZone string // 空の場合は起動テンプレートのサブネット
+	SubnetID         string
+}
+
+func (c candidate) String() string {
+	if c.AvailabilityZone == "" {
+		return c.InstanceType
+	}
+	return c.InstanceType + "@" + c.AvailabilityZone
+}
+
+// candidates は中断イベントから起動候補を試す順に返す
+// 同じアベイラビリティーゾーンは容量が戻っていない可能性が高いため、preferOtherZones の場合は後回しにする
+// インスタンスタイプごとに全てのゾーンを試してから次のタイプに移る
+func candidates(config *Config, event *failover.InterruptedEvent) ([]candidate, error) {
+	types := config.InstanceTypes
+	if len(types) == 0 {
+		if event.InstanceType == "" {
+			return nil, fmt.Errorf("event has no instance type and instanceTypes is not configured")
+		}
+		types = []string{event.InstanceType}
+	}
+
+	zones := config.zones()
+	if config.PreferOtherZones && event.AvailabilityZone != "" {
+		var others, same []string
+		for _, zone := range zones {
+			if zone == event.AvailabilityZone {
+				same = append(same, zone)
+			} else {
+				others = append(others, zone)
+			}
+		}
+		zones = append(others, same...)
+	}
+
+	var list []candidate
+	for _, instanceType := range types {
+		if len(zones) == 0 {
+			list = append(list, candidate{InstanceType: instanceType})
+			continue
+		}
+		for _, zone := range zones {
+			list = append(list, candidate{InstanceType: instanceType, AvailabilityZone: zone, SubnetID: config.Subnets[zone]})
+		}
+	}
+	return list, nil
+}
+
+// replacer は中断イベントを受けて置き換えのインスタンスを起動する
+type replacer struct {
+	config   *Config
+	launcher instanceLauncher
+}
+
+// replace は置き換えのインスタンスを起動し、そのIDを返す
+// 同じインスタンスの置き換えを起動済みの場合 (メッセージが重複して届いた場合など) は起動しない
+// 容量不足などで起動できない場合は次の候補を試す
+// stop/hibernate のインスタンスは容量が戻れば再開され、置き換えと二重に動いてしまうため起動しない
+func (r *replacer) replace(ctx context.Context, event *failover.InterruptedEvent) (string, error) {
+	if event.Action != "" && event.Action != actionTerminate {
+		log.Printf("%s will be resumed after %s, not terminated. Not launching a replacement.", event.InstanceID, event.Action)
+		return "", nil
+	}
+
+	existing, err := r.launcher.FindReplacement(ctx, event.InstanceID)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up existing replacement: %w", err)
+	}
+	if existing != "" {
+		log.Printf("Replacement %s for %s is already running. Skipping.", existing, event.InstanceID)
+		return existing, nil
+	}
+
+	list, err := candidates(r.config, event)
+	if err != nil {
+		return "", err
+	}
+	tags := map[string]string{tagReplaces: event.InstanceID}
+	if event.LatestBackup != nil {
+		tags[tagRestoreBackup] = event.LatestBackup.URI()
+	}
+
+	var errs []error
+	for _, c := range list {
+		id, err := r.launcher.Launch(ctx, launchRequest{candidate: c, Tags: tags})
+		if err != nil {
+			log.Printf("Could not launch %s: %v", c, err)
+			errs = append(errs, fmt.Errorf("%s: %w", c, err))
+			continue
+		}
+		log.Printf("Launched replacement %s (%s) for %s.", id, c, event.InstanceID)
+		return id, nil
+	}
+	return "", fmt.Errorf("no candidate could be launched: %w", errors.Join(errs...))
+}
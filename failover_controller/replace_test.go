@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"common/failover"
+)
+
+var testSubnets = map[string]string{
+	"ap-northeast-1a": "subnet-a",
+	"ap-northeast-1c": "subnet-c",
+	"ap-northeast-1d": "subnet-d",
+}
+
+// newTestReplacer は偽のEC2を使う replacer を返す
+func newTestReplacer(config *Config) (*replacer, *fakeEC2) {
+	config.LaunchTemplate = LaunchTemplateConfig{ID: "lt-test", Version: "$Latest"}
+	fake := newFakeEC2(config)
+	return &replacer{config: config, launcher: &ec2Launcher{client: fake, config: config}}, fake
+}
+
+func testEvent(action string) *failover.InterruptedEvent {
+	return &failover.InterruptedEvent{
+		Type:             failover.EventInterrupted,
+		InstanceID:       "i-interrupted",
+		InstanceType:     "m5.large",
+		AvailabilityZone: "ap-northeast-1a",
+		Action:           action,
+		LatestBackup:     &failover.BackupLocation{Bucket: "backups", Key: "minecraft_backups/world_20261019_010203.tar.gz"},
+	}
+}
+
+func tagValue(instance types.Instance, key string) string {
+	for _, tag := range instance.Tags {
+		if aws.ToString(tag.Key) == key {
+			return aws.ToString(tag.Value)
+		}
+	}
+	return ""
+}
+
+func TestReplaceCandidates(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		// 起動されるはずのインスタンスタイプとサブネット (空の場合は起動できない)
+		wantType   string
+		wantSubnet string
+	}{
+		{
+			name:     "same type without subnets",
+			config:   Config{PreferOtherZones: true},
+			wantType: "m5.large",
+		},
+		{
+			name:       "other zone first",
+			config:     Config{PreferOtherZones: true, Subnets: testSubnets},
+			wantType:   "m5.large",
+			wantSubnet: "subnet-c",
+		},
+		{
+			name:       "same zone in name order",
+			config:     Config{PreferOtherZones: false, Subnets: testSubnets},
+			wantType:   "m5.large",
+			wantSubnet: "subnet-a",
+		},
+		{
+			name: "next zone when out of capacity",
+			config: Config{PreferOtherZones: true, Subnets: testSubnets,
+				Fake: FakeConfig{Unavailable: []string{"m5.large@ap-northeast-1c"}}},
+			wantType:   "m5.large",
+			wantSubnet: "subnet-d",
+		},
+		{
+			name: "interrupted zone last",
+			config: Config{PreferOtherZones: true, Subnets: testSubnets,
+				Fake: FakeConfig{Unavailable: []string{"m5.large@ap-northeast-1c", "m5.large@ap-northeast-1d"}}},
+			wantType:   "m5.large",
+			wantSubnet: "subnet-a",
+		},
+		{
+			name: "next instance type when no zone has capacity",
+			config: Config{PreferOtherZones: true, Subnets: testSubnets, InstanceTypes: []string{"m6i.large", "m6a.large"},
+				Fake: FakeConfig{Unavailable: []string{"m6i.large"}}},
+			wantType:   "m6a.large",
+			wantSubnet: "subnet-c",
+		},
+		{
+			name: "no capacity anywhere",
+			config: Config{PreferOtherZones: true, Subnets: testSubnets,
+				Fake: FakeConfig{Unavailable: []string{"m5.large"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, fake := newTestReplacer(&tt.config)
+			id, err := r.replace(t.Context(), testEvent("terminate"))
+
+			if tt.wantType == "" {
+				if err == nil {
+					t.Fatalf("replace = %q, want an error", id)
+				}
+				if len(fake.instances) != 0 {
+					t.Errorf("launched %d instances, want none", len(fake.instances))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("replace: %v", err)
+			}
+			if len(fake.instances) != 1 {
+				t.Fatalf("launched %d instances, want 1", len(fake.instances))
+			}
+			instance := fake.instances[0]
+			if aws.ToString(instance.InstanceId) != id {
+				t.Errorf("replace = %q, want the launched instance %q", id, aws.ToString(instance.InstanceId))
+			}
+			if string(instance.InstanceType) != tt.wantType {
+				t.Errorf("instance type = %s, want %s", instance.InstanceType, tt.wantType)
+			}
+			if subnet := aws.ToString(instance.SubnetId); subnet != tt.wantSubnet {
+				t.Errorf("subnet = %q, want %q", subnet, tt.wantSubnet)
+			}
+			if got := tagValue(instance, tagReplaces); got != "i-interrupted" {
+				t.Errorf("%s tag = %q, want i-interrupted", tagReplaces, got)
+			}
+			if got, want := tagValue(instance, tagRestoreBackup), "s3://backups/minecraft_backups/world_20261019_010203.tar.gz"; got != want {
+				t.Errorf("%s tag = %q, want %q", tagRestoreBackup, got, want)
+			}
+		})
+	}
+}
+
+func TestReplaceIsIdempotent(t *testing.T) {
+	r, fake := newTestReplacer(&Config{PreferOtherZones: true, Subnets: testSubnets})
+	event := testEvent("terminate")
+
+	first, err := r.replace(t.Context(), event)
+	if err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	// 同じメッセージが重複して届いても、起動済みの置き換えを返す
+	second, err := r.replace(t.Context(), event)
+	if err != nil {
+		t.Fatalf("replace again: %v", err)
+	}
+	if second != first {
+		t.Errorf("second replace = %q, want %q", second, first)
+	}
+	if len(fake.instances) != 1 {
+		t.Errorf("launched %d instances, want 1", len(fake.instances))
+	}
+
+	// 置き換えが止まっている場合は起動し直す
+	fake.instances[0].State.Name = types.InstanceStateNameTerminated
+	third, err := r.replace(t.Context(), event)
+	if err != nil {
+		t.Fatalf("replace after the replacement stopped: %v", err)
+	}
+	if third == first || len(fake.instances) != 2 {
+		t.Errorf("replace = %q with %d instances, want a new instance", third, len(fake.instances))
+	}
+}
+
+func TestReplaceOnlyTerminated(t *testing.T) {
+	tests := []struct {
+		action     string
+		wantLaunch bool
+	}{
+		{"terminate", true},
+		// 古い spot-handler はアクションを送らない
+		{"", true},
+		// stop/hibernate のインスタンスは容量が戻れば再開される
+		{"stop", false},
+		{"hibernate", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			r, fake := newTestReplacer(&Config{PreferOtherZones: true})
+			body, err := json.Marshal(testEvent(tt.action))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 置き換えない場合もメッセージは削除する
+			if !handleMessage(t.Context(), r, string(body)) {
+				t.Error("handleMessage = false, want the message to be deleted")
+			}
+			if launched := len(fake.instances) > 0; launched != tt.wantLaunch {
+				t.Errorf("launched = %v, want %v", launched, tt.wantLaunch)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// GCPとAzureのメタデータサーバーのエミュレーション
+// シナリオの instanceAction を、GCPではプリエンプト、Azureでは Preempt イベントとして返す
+
+const azureEventID = "602d9444-d2cd-49c7-8624-8643e7171297"
+
+// gcpRoutes はGCPのメタデータサーバーのハンドラーを登録する
+func (e *emulator) gcpRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/computeMetadata/v1/instance/preempted", e.gcpHandler(func(s state) string {
+		if s.action != nil {
+			return "TRUE"
+		}
+		return "FALSE"
+	}))
+	mux.HandleFunc("/computeMetadata/v1/instance/id", e.gcpHandler(func(state) string {
+		return e.scenario.InstanceID
+	}))
+}
+
+// gcpHandler は Metadata-Flavor ヘッダーの確認と wait_for_change に対応したハンドラーを返す
+func (e *emulator) gcpHandler(value func(state) string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			log.Printf("GET %s -> 403 (missing Metadata-Flavor header)", r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if status, ok := e.fault(r.URL.Path); ok {
+			log.Printf("GET %s -> %d (fault)", r.URL.Path, status)
+			w.WriteHeader(status)
+			return
+		}
+
+		current := value(e.current(time.Since(e.start)))
+		q := r.URL.Query()
+		if q.Get("wait_for_change") == "true" {
+			// 値が last_etag から変わるか、タイムアウトするまで応答を返さない
+			timeout := 300 * time.Second
+			if sec, err := strconv.Atoi(q.Get("timeout_sec")); err == nil && sec > 0 {
+				timeout = time.Duration(sec) * time.Second
+			}
+			deadline := time.Now().Add(timeout)
+			for gcpETag(current) == q.Get("last_etag") && time.Now().Before(deadline) {
+				select {
+				case <-r.Context().Done():
+					return
+				case <-time.After(500 * time.Millisecond):
+				}
+				current = value(e.current(time.Since(e.start)))
+			}
+		}
+
+		log.Printf("GET %s -> 200 (%s)", r.URL.Path, current)
+		w.Header().Set("Metadata-Flavor", "Google")
+		w.Header().Set("ETag", gcpETag(current))
+		fmt.Fprint(w, current)
+	}
+}
+
+func gcpETag(value string) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(value)))
+}
+
+// azureRoutes はAzureのIMDSのハンドラーを登録する
+func (e *emulator) azureRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/metadata/scheduledevents", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			log.Printf("%s %s -> 400 (missing Metadata header)", r.Method, r.URL.Path)
+			http.Error(w, `{"error":"Bad request. Required metadata header not specified"}`, http.StatusBadRequest)
+			return
+		}
+		if status, ok := e.fault(r.URL.Path); ok {
+			log.Printf("%s %s -> %d (fault)", r.Method, r.URL.Path, status)
+			w.WriteHeader(status)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			e.azureScheduledEvents(w, r)
+		case http.MethodPost:
+			e.azureStartRequests(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/metadata/instance/compute/name", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, e.scenario.InstanceID)
+	})
+}
+
+func (e *emulator) azureScheduledEvents(w http.ResponseWriter, r *http.Request) {
+	s := e.current(time.Since(e.start))
+
+	e.mu.Lock()
+	acknowledged := e.acknowledged
+	e.mu.Unlock()
+
+	events := []map[string]any{}
+	incarnation := 1
+	if s.action != nil {
+		incarnation = 2
+		event := map[string]any{
+			"EventId":           azureEventID,
+			"EventType":         "Preempt",
+			"ResourceType":      "VirtualMachine",
+			"Resources":         []string{e.scenario.InstanceID},
+			"EventStatus":       "Scheduled",
+			"NotBefore":         e.start.Add(s.action.Time.Duration).UTC().Format(http.TimeFormat),
+			"Description":       "",
+			"EventSource":       "Platform",
+			"DurationInSeconds": -1,
+		}
+		if acknowledged {
+			// 承認されたイベントは開始済みになり、NotBefore が空になる
+			incarnation = 3
+			event["EventStatus"] = "Started"
+			event["NotBefore"] = ""
+		}
+		events = append(events, event)
+	}
+
+	log.Printf("GET %s -> 200 (%d event(s))", r.URL.Path, len(events))
+	json.NewEncoder(w).Encode(map[string]any{"DocumentIncarnation": incarnation, "Events": events})
+}
+
+// azureStartRequests はイベントの承認 (StartRequests) を受け付ける
+func (e *emulator) azureStartRequests(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		StartRequests []struct {
+			EventID string `json:"EventId"`
+		} `json:"StartRequests"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("POST %s -> 400 (%v)", r.URL.Path, err)
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	for _, sr := range req.StartRequests {
+		if sr.EventID != azureEventID {
+			log.Printf("POST %s -> 400 (unknown event %s)", r.URL.Path, sr.EventID)
+			http.Error(w, "unknown event", http.StatusBadRequest)
+			return
+		}
+	}
+
+	e.mu.Lock()
+	e.acknowledged = true
+	e.mu.Unlock()
+	log.Printf("POST %s -> 200 (acknowledged %d event(s))", r.URL.Path, len(req.StartRequests))
+}
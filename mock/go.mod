@@ -0,0 +1,5 @@
+module mock
+
+go 1.24.5
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -1,19 +1,290 @@
+// mock_server はローカルでspot-handlerをテストするためのIMDSエミュレーターです。
+//
+// 使い方:
+//
+//	go run . -scenario scenarios/rebalance_then_terminate.yaml
+//
+// spot-handlerの config.yaml で imds.baseUrl を http://localhost:8080 にして起動します。
+// GCP (instance/preempted) とAzure (Scheduled Events) のエンドポイントも同じポートで応答するため、
+// provider を gcp や azure にして gcp.baseUrl / azure.baseUrl を指定すれば同じシナリオでテストできます。
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 )
 
+// IMDSv2トークンの有効期間の上限 (秒)
+const maxTokenTTLSeconds = 21600
+
+// emulator はシナリオに従ってIMDSの応答を返す
+type emulator struct {
+	scenario *Scenario
+	start    time.Time
+
+	mu       sync.Mutex
+	tokens   map[string]time.Time // トークン → 有効期限
+	expired  int                  // 適用済みの expireTokens の数
+	requests map[int]int          // Fault ごとのリクエスト数
+	// Azureの Preempt イベントが承認されたか
+	acknowledged bool
+}
+
+func newEmulator(scenario *Scenario) *emulator {
+	return &emulator{
+		scenario: scenario,
+		start:    time.Now(),
+		tokens:   make(map[string]time.Time),
+		requests: make(map[int]int),
+	}
+}
+
+// state は現時点で有効なメタデータ
+type state struct {
+	rebalance       *time.Time
+	action          *ScenarioAction
+	scheduledEvents []ScenarioMaintenance
+}
+
+// current は経過時間までに発生したイベントを順に適用した状態を返す
+func (e *emulator) current(elapsed time.Duration) state {
+	var s state
+	for _, event := range e.scenario.Events {
+		if event.At.Duration > elapsed {
+			continue
+		}
+		if event.Rebalance && s.rebalance == nil {
+			t := e.start.Add(event.At.Duration)
+			s.rebalance = &t
+		}
+		if event.InstanceAction != nil {
+			s.action = event.InstanceAction
+		}
+		if event.ScheduledEvents != nil {
+			s.scheduledEvents = *event.ScheduledEvents
+		}
+	}
+	return s
+}
+
+func (e *emulator) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/api/token", e.handleToken)
+	e.handleMetadata(mux, "/latest/meta-data/instance-id", func(state) (int, string) {
+		return http.StatusOK, e.scenario.InstanceID
+	})
+	e.handleMetadata(mux, "/latest/meta-data/instance-type", func(state) (int, string) {
+		return http.StatusOK, e.scenario.InstanceType
+	})
+	e.handleMetadata(mux, "/latest/meta-data/placement/availability-zone", func(state) (int, string) {
+		return http.StatusOK, e.scenario.AvailabilityZone
+	})
+	e.handleMetadata(mux, "/latest/meta-data/public-ipv4", func(state) (int, string) {
+		if e.scenario.PublicIPv4 == "" {
+			return http.StatusNotFound, ""
+		}
+		return http.StatusOK, e.scenario.PublicIPv4
+	})
+	e.handleMetadata(mux, "/latest/meta-data/spot/instance-action", func(s state) (int, string) {
+		if s.action == nil {
+			return http.StatusNotFound, ""
+		}
+		return jsonBody(map[string]string{
+			"action": s.action.Action,
+			"time":   e.start.Add(s.action.Time.Duration).UTC().Format(time.RFC3339),
+		})
+	})
+	e.handleMetadata(mux, "/latest/meta-data/events/recommendations/rebalance", func(s state) (int, string) {
+		if s.rebalance == nil {
+			return http.StatusNotFound, ""
+		}
+		return jsonBody(map[string]string{"noticeTime": s.rebalance.UTC().Format(time.RFC3339)})
+	})
+	e.handleMetadata(mux, "/latest/meta-data/events/maintenance/scheduled", func(s state) (int, string) {
+		// 予定がない場合は空のリストを返す
+		events := make([]map[string]string, 0, len(s.scheduledEvents))
+		for _, m := range s.scheduledEvents {
+			events = append(events, map[string]string{
+				"Code":        m.Code,
+				"Description": m.Description,
+				"EventId":     m.EventID,
+				"NotBefore":   e.eventTime(m.NotBefore),
+				"NotAfter":    e.eventTime(m.NotAfter),
+				"State":       m.State,
+			})
+		}
+		return jsonBody(events)
+	})
+	e.gcpRoutes(mux)
+	e.azureRoutes(mux)
+	return mux
+}
+
+// eventTime はメンテナンスイベントの時刻をIMDSと同じ形式にする
+func (e *emulator) eventTime(d Duration) string {
+	if d.Duration == 0 {
+		return ""
+	}
+	return e.start.Add(d.Duration).UTC().Format("2 Jan 2006 15:04:05 GMT")
+}
+
+// handleToken は PUT /latest/api/token でIMDSv2トークンを発行する
+func (e *emulator) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if status, ok := e.fault(r.URL.Path); ok {
+		log.Printf("PUT %s -> %d (fault)", r.URL.Path, status)
+		w.WriteHeader(status)
+		return
+	}
+	ttl, err := strconv.Atoi(r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds"))
+	if err != nil || ttl < 1 || ttl > maxTokenTTLSeconds {
+		log.Printf("PUT %s -> 400 (invalid ttl %q)", r.URL.Path, r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds"))
+		http.Error(w, "invalid ttl", http.StatusBadRequest)
+		return
+	}
+
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	token := hex.EncodeToString(buf)
+
+	e.mu.Lock()
+	e.expireTokens()
+	e.tokens[token] = time.Now().Add(time.Duration(ttl) * time.Second)
+	e.mu.Unlock()
+
+	log.Printf("PUT %s -> 200 (ttl %ds)", r.URL.Path, ttl)
+	w.Header().Set("X-aws-ec2-metadata-token-ttl-seconds", strconv.Itoa(ttl))
+	fmt.Fprint(w, token)
+}
+
+// handleMetadata はトークンの確認とエラーの注入を行ってから body の結果を返すハンドラーを登録する
+func (e *emulator) handleMetadata(mux *http.ServeMux, path string, body func(state) (int, string)) {
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if status, ok := e.fault(path); ok {
+			log.Printf("GET %s -> %d (fault)", path, status)
+			w.WriteHeader(status)
+			return
+		}
+		if !e.authorized(r) {
+			log.Printf("GET %s -> 401 (missing or invalid token)", path)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		status, text := body(e.current(time.Since(e.start)))
+		log.Printf("GET %s -> %d", path, status)
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		fmt.Fprint(w, text)
+	})
+}
+
+// authorized はトークンを確認する
+// トークンのないリクエストは requireToken が false の場合のみ許可する (IMDSv1)
+func (e *emulator) authorized(r *http.Request) bool {
+	token := r.Header.Get("X-aws-ec2-metadata-token")
+	if token == "" {
+		return !e.scenario.RequireToken
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.expireTokens()
+	expiry, ok := e.tokens[token]
+	return ok && time.Now().Before(expiry)
+}
+
+// expireTokens はシナリオの expireTokens に達していたら発行済みのトークンを破棄する
+// 呼び出し側で e.mu をロックしておくこと
+func (e *emulator) expireTokens() {
+	elapsed := time.Since(e.start)
+	count := 0
+	for _, event := range e.scenario.Events {
+		if event.ExpireTokens && event.At.Duration <= elapsed {
+			count++
+		}
+	}
+	if count > e.expired {
+		log.Printf("Expiring %d issued token(s)", len(e.tokens))
+		e.tokens = make(map[string]time.Time)
+		e.expired = count
+	}
+}
+
+// fault は現在有効なエラー設定に該当する場合、返すステータスコードを返す
+func (e *emulator) fault(path string) (int, bool) {
+	elapsed := time.Since(e.start)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, f := range e.scenario.Faults {
+		if elapsed < f.From.Duration || (f.Until.Duration != 0 && elapsed >= f.Until.Duration) {
+			continue
+		}
+		if !matchPath(f.Paths, path) {
+			continue
+		}
+		e.requests[i]++
+		if f.Every <= 1 || e.requests[i]%f.Every == 0 {
+			return f.Status, true
+		}
+	}
+	return 0, false
+}
+
+func matchPath(paths []string, path string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonBody(v any) (int, string) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return http.StatusInternalServerError, ""
+	}
+	return http.StatusOK, string(data)
+}
+
 func main() {
-    http.HandleFunc("/mock-interruption", func(w http.ResponseWriter, r *http.Request) {
-        // "中断通知あり" をシミュレート
-        w.WriteHeader(http.StatusOK)
-        fmt.Fprintf(w, `{"action": "terminate", "time": "%s"}`, time.Now().Add(2*time.Minute).Format(time.RFC3339))
-        log.Println("Sent mock interruption notice.")
-    })
-    log.Println("Mock server starting on :8080...")
-    http.ListenAndServe(":8080", nil)
+	addr := flag.String("addr", ":8080", "Address to listen on")
+	scenarioPath := flag.String("scenario", "", "Path to a scenario YAML file (default: rebalance now, terminate in 2 minutes)")
+	flag.Parse()
+
+	scenario := defaultScenario()
+	if *scenarioPath != "" {
+		var err error
+		scenario, err = loadScenario(*scenarioPath)
+		if err != nil {
+			log.Fatalf("Fatal: Could not load scenario from %s. %v", *scenarioPath, err)
+		}
+	}
+	scenario.setDefaults()
+
+	e := newEmulator(scenario)
+	log.Printf("IMDS emulator starting on %s (instance %s, requireToken=%t)...", *addr, scenario.InstanceID, scenario.RequireToken)
+	log.Fatal(http.ListenAndServe(*addr, e.routes()))
 }
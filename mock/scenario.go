@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario はエミュレーターが返す内容を時間経過に沿って定義したもの
+// 時刻はすべてエミュレーター起動時からの経過時間で指定する
+type Scenario struct {
+	InstanceID       string `yaml:"instanceId"`
+	InstanceType     string `yaml:"instanceType"`
+	AvailabilityZone string `yaml:"availabilityZone"`
+	PublicIPv4       string `yaml:"publicIpv4"`
+	// true にするとトークンのないリクエストを401で拒否する (IMDSv2のみ有効なインスタンス)
+	RequireToken bool `yaml:"requireToken"`
+
+	// 中断通知やリバランス推奨などを出すタイミング
+	Events []ScenarioEvent `yaml:"events"`
+	// 一定期間だけエラーを返す設定
+	Faults []Fault `yaml:"faults"`
+}
+
+// ScenarioEvent は At の時点から有効になるメタデータ
+type ScenarioEvent struct {
+	At Duration `yaml:"at"`
+
+	// リバランス推奨を出す (noticeTime は At の時刻)
+	Rebalance bool `yaml:"rebalance"`
+	// 中断通知を出す
+	InstanceAction *ScenarioAction `yaml:"instanceAction"`
+	// メンテナンスイベントを出す (空のリストを指定すると取り消す)
+	ScheduledEvents *[]ScenarioMaintenance `yaml:"scheduledEvents"`
+	// 発行済みのトークンをすべて無効にする
+	ExpireTokens bool `yaml:"expireTokens"`
+}
+
+// ScenarioAction は中断通知の内容
+type ScenarioAction struct {
+	// terminate, stop, hibernate
+	Action string `yaml:"action"`
+	// 中断時刻 (起動からの経過時間)
+	Time Duration `yaml:"time"`
+}
+
+// ScenarioMaintenance はメンテナンスイベントの内容
+type ScenarioMaintenance struct {
+	Code        string   `yaml:"code"`
+	Description string   `yaml:"description"`
+	EventID     string   `yaml:"eventId"`
+	NotBefore   Duration `yaml:"notBefore"`
+	NotAfter    Duration `yaml:"notAfter"`
+	State       string   `yaml:"state"`
+}
+
+// Fault は From から Until までの間、指定したパスへのリクエストにエラーを返す設定
+type Fault struct {
+	From  Duration `yaml:"from"`
+	Until Duration `yaml:"until"`
+	// 返すステータスコード (例: 500, 401)
+	Status int `yaml:"status"`
+	// N回に1回だけエラーを返す (0または1なら毎回)
+	Every int `yaml:"every"`
+	// 対象のパス。空の場合はすべてのパス (トークンの取得を含む)
+	Paths []string `yaml:"paths"`
+}
+
+// Duration は "30s" や "2m" 形式で書ける time.Duration
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	parsed, err := time.ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q", node.Line, node.Value)
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// defaultScenario はシナリオファイルを指定しなかった場合の動作
+// 起動直後からリバランス推奨と2分後の中断通知を返す
+func defaultScenario() *Scenario {
+	return &Scenario{
+		Events: []ScenarioEvent{
+			{
+				Rebalance:      true,
+				InstanceAction: &ScenarioAction{Action: "terminate", Time: Duration{2 * time.Minute}},
+			},
+		},
+	}
+}
+
+func loadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario file: %w", err)
+	}
+
+	var scenario Scenario
+	if err := yaml.Unmarshal(data, &scenario); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal scenario yaml: %w", err)
+	}
+
+	for i, event := range scenario.Events {
+		if a := event.InstanceAction; a != nil {
+			switch a.Action {
+			case "terminate", "stop", "hibernate":
+			default:
+				return nil, fmt.Errorf("events[%d]: unknown instance action %q", i, a.Action)
+			}
+		}
+	}
+	for i, fault := range scenario.Faults {
+		if fault.Status < 400 || fault.Status > 599 {
+			return nil, fmt.Errorf("faults[%d]: status must be 4xx or 5xx: %d", i, fault.Status)
+		}
+		if fault.Until.Duration != 0 && fault.Until.Duration <= fault.From.Duration {
+			return nil, fmt.Errorf("faults[%d]: until must be after from", i)
+		}
+	}
+	return &scenario, nil
+}
+
+func (s *Scenario) setDefaults() {
+	if s.InstanceID == "" {
+		s.InstanceID = "i-0123456789abcdef0"
+	}
+	if s.InstanceType == "" {
+		s.InstanceType = "t3.large"
+	}
+	if s.AvailabilityZone == "" {
+		s.AvailabilityZone = "ap-northeast-1a"
+	}
+}
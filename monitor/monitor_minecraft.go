@@ -3,50 +3,83 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"os/exec" // for 'aws s3 sync' if needed
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
-	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
-	"github.com/HimbeerserverDE/rcon"
+
+	"common/countdown"
+	"common/imds"
+	"common/rcon"
 )
 
 // 設定用の変数（デフォルト値）
 const (
-	defaultRCONHost          = "localhost"
-	defaultRCONPort          = 25575
+	defaultRCONHost             = "localhost"
+	defaultRCONPort             = 25575
 	defaultStopThresholdMinutes = 15 // X分間プレイヤーがいない場合に停止
-	counterFile              = "/tmp/minecraft_zero_players_counter"
-	lastStopSentFile         = "/tmp/minecraft_last_stop_sent_timestamp"
+	counterFile                 = "/tmp/minecraft_zero_players_counter"
+	lastStopSentFile            = "/tmp/minecraft_last_stop_sent_timestamp"
 )
 
 var (
-	rconHost          string
-	rconPort          int
-	rconPassword      string
-	sqsQueueURL       string
+	rconHost             string
+	rconPort             int
+	rconPassword         string
+	sqsQueueURL          string
 	stopThresholdMinutes int
+	countdownPoints      []time.Duration
+	countdownLanguage    string
 )
 
+// errPlayersReturned はカウントダウン中にプレイヤーが戻ってきたことを示す
+var errPlayersReturned = errors.New("players came back during the countdown")
+
 func init() {
 	rconHost = getEnvOrDefault("RCON_HOST", defaultRCONHost)
 	rconPortStr := getEnvOrDefault("RCON_PORT", strconv.Itoa(defaultRCONPort))
 	rconPort, _ = strconv.Atoi(rconPortStr)
-	if rconPort == 0 { rconPort = defaultRCONPort }
+	if rconPort == 0 {
+		rconPort = defaultRCONPort
+	}
 
+	// パスワードはファイルからの読み込みを優先する (環境変数は ps や /proc から見えてしまうため)
 	rconPassword = os.Getenv("RCON_PASSWORD")
+	if passwordFile := os.Getenv("RCON_PASSWORD_FILE"); passwordFile != "" {
+		password, err := rcon.ReadPasswordFile(passwordFile)
+		if err != nil {
+			fmt.Printf("Warning: %v. Falling back to RCON_PASSWORD.\n", err)
+		} else {
+			rconPassword = password
+		}
+	}
 	sqsQueueURL = os.Getenv("SQS_QUEUE_URL")
 
 	stopThresholdStr := getEnvOrDefault("STOP_THRESHOLD_MINUTES", strconv.Itoa(defaultStopThresholdMinutes))
 	stopThresholdMinutes, _ = strconv.Atoi(stopThresholdStr)
-	if stopThresholdMinutes == 0 { stopThresholdMinutes = defaultStopThresholdMinutes } // 閾値変換失敗時
+	if stopThresholdMinutes == 0 {
+		stopThresholdMinutes = defaultStopThresholdMinutes
+	} // 閾値変換失敗時
+
+	// 停止前のカウントダウン (例: "90,60,30,10")。空の場合はデフォルトのタイミング
+	countdownPoints = countdown.DefaultPoints
+	if pointsStr := os.Getenv("COUNTDOWN_POINTS"); pointsStr != "" {
+		points, err := countdown.ParsePoints(pointsStr)
+		if err != nil {
+			fmt.Printf("Warning: invalid COUNTDOWN_POINTS: %v. Using defaults.\n", err)
+		} else {
+			countdownPoints = points
+		}
+	}
+	countdownLanguage = getEnvOrDefault("COUNTDOWN_LANG", "ja")
 }
 
 func getEnvOrDefault(key, defaultValue string) string {
@@ -56,27 +89,28 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
-func getPlayerCount(ctx context.Context) (int, error) {
+// newRCONClient は環境変数の設定でRCONクライアントを作成する
+func newRCONClient() *rcon.Client {
+	return rcon.NewClient(fmt.Sprintf("%s:%d", rconHost, rconPort), rconPassword)
+}
+
+func getPlayerCount(ctx context.Context, client *rcon.Client) (int, error) {
 	if rconPassword == "" {
-		return -1, fmt.Errorf("RCON_PASSWORD environment variable is not set")
+		return -1, fmt.Errorf("RCON_PASSWORD or RCON_PASSWORD_FILE environment variable is not set")
 	}
 
 	// 接続タイムアウトを設定
-	conn, err := rcon.DialWithTimeout(fmt.Sprintf("%s:%d", rconHost, rconPort), time.Second*5)
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	response, err := client.Execute(ctx, "list")
 	if err != nil {
-		if strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "dial timeout") {
+		switch {
+		case errors.Is(err, rcon.ErrConnectionRefused), errors.Is(err, rcon.ErrTimeout):
 			return -2, fmt.Errorf("RCON connection failed: %w (server might be down or not responding)", err)
+		case errors.Is(err, rcon.ErrAuthFailed):
+			return -4, fmt.Errorf("RCON authentication failed: %w", err)
 		}
-		return -3, fmt.Errorf("failed to dial RCON: %w", err)
-	}
-	defer conn.Close()
-
-	if err := conn.Authenticate(rconPassword); err != nil {
-		return -4, fmt.Errorf("RCON authentication failed: %w", err)
-	}
-
-	response, err := conn.Execute("list")
-	if err != nil {
 		return -5, fmt.Errorf("failed to execute 'list' command: %w", err)
 	}
 	fmt.Printf("RCON Response: %s\n", response)
@@ -118,17 +152,14 @@ func writeFileContent(filePath string, content float64) error {
 	return ioutil.WriteFile(filePath, []byte(fmt.Sprintf("%.0f", content)), 0644) // 整数として保存
 }
 
-func getInstanceID() (string, error) {
-	resp, err := http.Get("http://169.254.169.254/latest/meta-data/instance-id")
+func getInstanceID(ctx context.Context) (string, error) {
+	// IMDSv2のみが有効なインスタンスでも取得できるよう、トークン付きでリクエストする
+	md := imds.New(imds.WithBaseURL(os.Getenv("IMDS_BASE_URL")))
+	id, err := md.InstanceID(ctx)
 	if err != nil {
 		return "", fmt.Errorf("failed to get instance ID from metadata: %w", err)
 	}
-	defer resp.Body.Close()
-	id, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read instance ID from metadata: %w", err)
-	}
-	return strings.TrimSpace(string(id)), nil
+	return id, nil
 }
 
 func sendSQSMessage(ctx context.Context, instanceID, queueURL string) error {
@@ -160,22 +191,43 @@ func sendSQSMessage(ctx context.Context, instanceID, queueURL string) error {
 	return nil
 }
 
-func minecraftServerStopCommand(ctx context.Context) error {
-	if rconPassword == "" {
-		return fmt.Errorf("RCON_PASSWORD environment variable is not set")
+// runIdleCountdown は停止前にゲーム内でカウントダウンを表示する
+// カウントダウン中にプレイヤーが戻ってきた場合は errPlayersReturned を返す
+func runIdleCountdown(ctx context.Context, client *rcon.Client) error {
+	longest := time.Duration(0)
+	for _, p := range countdownPoints {
+		if p > longest {
+			longest = p
+		}
 	}
-
-	conn, err := rcon.DialWithTimeout(fmt.Sprintf("%s:%d", rconHost, rconPort), time.Second*5)
-	if err != nil {
-		return fmt.Errorf("failed to connect to RCON for stop command: %w", err)
+	b := &countdown.Broadcaster{
+		Commander: client,
+		Points:    countdownPoints,
+		Language:  countdownLanguage,
+		Reason:    countdown.ReasonIdle,
+		Check: func(ctx context.Context) error {
+			count, err := getPlayerCount(ctx, client)
+			if err == nil && count > 0 {
+				return errPlayersReturned
+			}
+			return nil
+		},
+		Logf: func(format string, args ...any) {
+			fmt.Printf(format+"\n", args...)
+		},
 	}
-	defer conn.Close()
+	return b.Run(ctx, time.Now().Add(longest))
+}
 
-	if err := conn.Authenticate(rconPassword); err != nil {
-		return fmt.Errorf("RCON authentication failed for stop command: %w", err)
+func minecraftServerStopCommand(ctx context.Context, client *rcon.Client) error {
+	if rconPassword == "" {
+		return fmt.Errorf("RCON_PASSWORD or RCON_PASSWORD_FILE environment variable is not set")
 	}
 
-	response, err := conn.Execute("stop")
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	response, err := client.Execute(ctx, "stop")
 	if err != nil {
 		return fmt.Errorf("failed to execute 'stop' command: %w", err)
 	}
@@ -197,18 +249,17 @@ func s3BackupWorld(worldPath, s3BucketPath string) error {
 	return nil
 }
 
-
 func main() {
 	ctx := context.Background()
 
-	instanceID, err := getInstanceID()
+	instanceID, err := getInstanceID(ctx)
 	if err != nil {
 		fmt.Printf("Error getting instance ID: %v. Exiting.\n", err)
 		return
 	}
 
 	if rconPassword == "" {
-		fmt.Println("Error: RCON_PASSWORD environment variable is not set. Exiting.")
+		fmt.Println("Error: RCON_PASSWORD or RCON_PASSWORD_FILE environment variable is not set. Exiting.")
 		return
 	}
 	if sqsQueueURL == "" {
@@ -216,7 +267,11 @@ func main() {
 		return
 	}
 
-	playerCount, err := getPlayerCount(ctx)
+	// プレイヤー数の確認と停止コマンドで同じ接続を使う
+	rconClient := newRCONClient()
+	defer rconClient.Close()
+
+	playerCount, err := getPlayerCount(ctx, rconClient)
 	if err != nil {
 		fmt.Printf("Error getting player count: %v. Treating as 0 for counter.\n", err)
 		// RCON接続エラーの場合、プレイヤーはいないと判断し0とする
@@ -248,10 +303,24 @@ func main() {
 	// SQSメッセージ送信から停止までタイムラグがあるため、多重送信を防ぐ
 	if currentZeroCount >= float64(stopThresholdMinutes) && (currentTime-lastStopSentTS) > float64(stopThresholdMinutes*60) {
 		fmt.Printf("Zero players for %.0f minutes. Sending stop request...\n", currentZeroCount)
-		
+
+		// カウントダウンは監視の実行間隔 (1分) より長くかかることがあるため、
+		// 次の実行が2回目のカウントダウンと停止を始めないよう、先に停止中であることを記録しておく
+		writeFileContent(lastStopSentFile, currentTime)
+
+		// 停止前にカウントダウンを表示する。途中でプレイヤーが戻ってきたら停止を取りやめる
+		if err := runIdleCountdown(ctx, rconClient); errors.Is(err, errPlayersReturned) {
+			fmt.Println("Players came back during the countdown. Cancelling stop.")
+			writeFileContent(counterFile, 0)
+			writeFileContent(lastStopSentFile, lastStopSentTS)
+			return
+		} else if err != nil {
+			fmt.Printf("Warning: Failed to broadcast countdown: %v\n", err)
+		}
+
 		// オプション: Minecraftサーバーに安全なシャットダウンコマンドを送信
 		// これにより、EC2が停止する前にゲームが安全に終了します。
-		if err := minecraftServerStopCommand(ctx); err != nil {
+		if err := minecraftServerStopCommand(ctx, rconClient); err != nil {
 			fmt.Printf("Warning: Failed to send Minecraft server stop command: %v\n", err)
 		}
 
@@ -261,8 +330,8 @@ func main() {
 
 		if err := sendSQSMessage(ctx, instanceID, sqsQueueURL); err != nil {
 			fmt.Printf("Failed to send stop request to SQS: %v. Will retry on next check.\n", err)
+			writeFileContent(lastStopSentFile, lastStopSentTS)
 		} else {
-			writeFileContent(lastStopSentFile, currentTime) // SQSメッセージ送信時刻を記録
 			fmt.Println("Stop request sent. EC2 should stop soon.")
 		}
 	} else if currentZeroCount >= float64(stopThresholdMinutes) {
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Discordの埋め込みメッセージの色 (10進数)
+const (
+	colorSuccess = 3066993  // 緑
+	colorWarning = 15105570 // オレンジ
+	colorInfo    = 16776960 // 黄
+)
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Color       int            `json:"color"`
+	Fields      []discordField `json:"fields,omitempty"`
+	Footer      struct {
+		Text string `json:"text"`
+	} `json:"footer"`
+}
+
+// sendDiscordNotification はDiscordのWebhookに埋め込みメッセージを送信する
+func sendDiscordNotification(ctx context.Context, webhookURL, title, message string, color int, fields []discordField) error {
+	embed := discordEmbed{
+		Title:       title,
+		Description: message,
+		Color:       color,
+		Fields: append(fields, discordField{
+			Name:   "Timestamp (UTC)",
+			Value:  time.Now().UTC().Format("2006-01-02 15:04:05"),
+			Inline: true,
+		}),
+	}
+	embed.Footer.Text = "Automated notification from spot-handler"
+
+	payload, err := json.Marshal(map[string]any{
+		"username":   "Minecraft Server Bot",
+		"avatar_url": "https://i.imgur.com/v1hGfV8.png",
+		"embeds":     []discordEmbed{embed},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal discord payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create discord request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send discord notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("discord webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"common/failover"
+)
+
+// FailoverConfig は中断イベント (置き換えのインスタンスを起動するコントローラー向け) の送信先
+type FailoverConfig struct {
+	// 中断イベントを送るSQSキューのURL。空の場合は送らない
+	QueueURL string `yaml:"queueUrl"`
+	// SQSのリージョン。空の場合はインスタンスのアベイラビリティーゾーンから決める
+	Region string `yaml:"region"`
+	// バックアップツールが書き込む最新のバックアップの記録 (BACKUP_OUTPUT_PATH/latest_backup.json)
+	LatestBackupFile string `yaml:"latestBackupFile"`
+}
+
+// publish はシャットダウン処理の最後に中断イベントをSQSに送る
+// 最新のバックアップはバックアップツールが記録した日時付きのキーで、次のバックアップで上書きされない
+func (r *shutdownRun) publish(ctx context.Context, p *Pipeline) error {
+	cfg := r.config.Failover
+	if cfg.QueueURL == "" {
+		return fmt.Errorf("%w: failover queue URL not set", errStepSkipped)
+	}
+	if r.simulate != "" {
+		// 置き換えのインスタンスが起動してしまうため、シミュレーションでは送らない
+		log.Printf("[simulate] Would publish an interrupted event (%s) to %s.", r.action.Action, cfg.QueueURL)
+		return nil
+	}
+
+	event := failover.InterruptedEvent{
+		Type:             failover.EventInterrupted,
+		Action:           r.action.Action,
+		InterruptionTime: r.action.Time.UTC(),
+		ShutdownFailed:   p.Failed() > 0,
+	}
+	id, err := r.instance.InstanceID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get instance ID: %w", err)
+	}
+	event.InstanceID = id
+	if d, ok := r.instance.(instanceDescriber); ok {
+		// 置き換えの候補を選ぶのに使うだけなので、取得できなくても送る
+		if event.AvailabilityZone, err = d.AvailabilityZone(ctx); err != nil {
+			log.Printf("Could not get availability zone: %v", err)
+		}
+		if event.InstanceType, err = d.InstanceType(ctx); err != nil {
+			log.Printf("Could not get instance type: %v", err)
+		}
+	}
+	if cfg.LatestBackupFile != "" {
+		backup, err := readLatestBackup(cfg.LatestBackupFile)
+		if err != nil {
+			log.Printf("Could not read the latest backup: %v", err)
+		}
+		event.LatestBackup = backup
+	}
+	event.PublishedAt = time.Now().UTC()
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal interrupted event: %w", err)
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = regionFromZone(event.AvailabilityZone)
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(region))
+	if err != nil {
+		return fmt.Errorf("failed to load AWS SDK config: %w", err)
+	}
+	_, err = sqs.NewFromConfig(awsCfg).SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(cfg.QueueURL),
+		MessageBody: aws.String(string(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send interrupted event: %w", err)
+	}
+	log.Printf("Interrupted event published: %s", body)
+	return nil
+}
+
+// readLatestBackup はバックアップツールが記録した最新のバックアップの場所を読み込む
+// まだバックアップを取っていない場合はnilを返す
+func readLatestBackup(path string) (*failover.BackupLocation, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var backup failover.BackupLocation
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return &backup, nil
+}
+
+// regionFromZone はアベイラビリティーゾーン (例: ap-northeast-1a) からリージョンを返す
+func regionFromZone(zone string) string {
+	return strings.TrimRight(zone, "abcdefghijklmnopqrstuvwxyz")
+}
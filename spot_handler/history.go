@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// 履歴の記録先のデフォルト
+const (
+	defaultHistoryFile     = "/var/lib/spot-handler/history.jsonl"
+	defaultHistoryS3Prefix = "spot-handler/history/"
+	// 履歴の書き込みとS3へのコピーにかける時間
+	historyTimeout = 10 * time.Second
+)
+
+// 履歴に記録するイベントの種類
+const (
+	historyInterruption = "interruption"
+	historyRebalance    = "rebalance"
+	historyMaintenance  = "maintenance"
+	// 中断のシャットダウン処理の結果。中断そのものは検知した時点で interruption として記録する
+	historyShutdown = "shutdown"
+)
+
+// HistoryConfig は中断やリバランス推奨などの履歴の記録先
+type HistoryConfig struct {
+	// 履歴を追記するファイル (JSON Lines)
+	File string `yaml:"file"`
+	// 履歴をコピーするS3バケット。空の場合はコピーしない
+	S3Bucket string `yaml:"s3Bucket"`
+	// S3のキーの接頭辞。インスタンスごとに <s3Prefix><インスタンスID>.jsonl に書き込む
+	S3Prefix string `yaml:"s3Prefix"`
+	// S3のリージョン。空の場合はインスタンスのアベイラビリティーゾーンから決める
+	Region string `yaml:"region"`
+}
+
+// historyEntry は履歴の1件
+type historyEntry struct {
+	Event string `json:"event"` // interruption, shutdown, rebalance, maintenance
+	// 中断時刻 (shutdown も同じ)、リバランス推奨の noticeTime、メンテナンスの NotBefore
+	Time       time.Time `json:"time"`
+	RecordedAt time.Time `json:"recordedAt"`
+	// 中断のアクション (terminate, stop, hibernate) またはメンテナンスのコード
+	Detail           string `json:"detail,omitempty"`
+	Provider         string `json:"provider"`
+	InstanceID       string `json:"instanceId,omitempty"`
+	InstanceType     string `json:"instanceType,omitempty"`
+	AvailabilityZone string `json:"availabilityZone,omitempty"`
+	// 記録した時点でのOSの起動からの時間
+	UptimeSeconds float64 `json:"uptimeSeconds,omitempty"`
+	// シャットダウン処理 (shutdown) または早期警告 (rebalance) にかかった時間と失敗したステップの数
+	PipelineSeconds float64 `json:"pipelineSeconds,omitempty"`
+	PipelineFailed  int     `json:"pipelineFailed,omitempty"`
+}
+
+// historyLog は履歴をファイルに追記し、S3にコピーする
+type historyLog struct {
+	config   *Config
+	instance interruptionProvider
+
+	// インスタンスの情報は変わらないため一度だけ取得する
+	described    bool
+	instanceID   string
+	instanceType string
+	zone         string
+}
+
+// record は履歴を1件追記する。失敗してもログに出すだけで処理は続ける
+func (h *historyLog) record(entry historyEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), historyTimeout)
+	defer cancel()
+
+	h.describe(ctx)
+	entry.RecordedAt = time.Now().UTC()
+	entry.Time = entry.Time.UTC()
+	entry.Provider = h.config.Provider
+	entry.InstanceID = h.instanceID
+	entry.InstanceType = h.instanceType
+	entry.AvailabilityZone = h.zone
+	if uptime, err := systemUptime(); err == nil {
+		entry.UptimeSeconds = uptime.Seconds()
+	}
+
+	if err := appendHistory(h.config.History.File, entry); err != nil {
+		log.Printf("Failed to record %s in history: %v", entry.Event, err)
+		return
+	}
+	if h.config.History.S3Bucket == "" {
+		return
+	}
+	if err := h.upload(ctx); err != nil {
+		log.Printf("Failed to copy history to S3: %v", err)
+	}
+}
+
+// describe はインスタンスID、インスタンスタイプ、アベイラビリティーゾーンを取得する
+func (h *historyLog) describe(ctx context.Context) {
+	if h.described {
+		return
+	}
+	id, err := h.instance.InstanceID(ctx)
+	if err != nil {
+		log.Printf("Could not get instance ID for history: %v", err)
+		return
+	}
+	h.instanceID = id
+	h.zone = availabilityZone(ctx, h.instance)
+	if d, ok := h.instance.(instanceDescriber); ok {
+		if h.instanceType, err = d.InstanceType(ctx); err != nil {
+			log.Printf("Could not get instance type: %v", err)
+		}
+	}
+	h.described = true
+}
+
+// upload は履歴のファイル全体をS3に書き込む
+// インスタンスが終了するとローカルのファイルは失われるため、追記のたびにコピーしておく
+func (h *historyLog) upload(ctx context.Context) error {
+	if h.instanceID == "" {
+		return fmt.Errorf("instance ID is unknown")
+	}
+	cfg := h.config.History
+	data, err := os.ReadFile(cfg.File)
+	if err != nil {
+		return err
+	}
+	client, err := newHistoryS3Client(ctx, cfg, h.zone)
+	if err != nil {
+		return err
+	}
+	key := cfg.S3Prefix + h.instanceID + ".jsonl"
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(cfg.S3Bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload s3://%s/%s: %w", cfg.S3Bucket, key, err)
+	}
+	return nil
+}
+
+func newHistoryS3Client(ctx context.Context, cfg HistoryConfig, zone string) (*s3.Client, error) {
+	region := cfg.Region
+	if region == "" {
+		region = regionFromZone(zone)
+	}
+	var opts []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS SDK config: %w", err)
+	}
+	return s3.NewFromConfig(awsCfg), nil
+}
+
+// appendHistory は履歴のファイルに1行追記する
+func appendHistory(path string, entry historyEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+	return f.Close()
+}
+
+// readHistory は履歴のファイルを読み込む。ファイルがない場合は空の履歴を返す
+func readHistory(path string) ([]historyEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return decodeHistory(f, path)
+}
+
+// decodeHistory はJSON Linesの履歴を読み込む。読めない行はログに出して飛ばす
+func decodeHistory(r io.Reader, name string) ([]historyEntry, error) {
+	var entries []historyEntry
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry historyEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			log.Printf("Skipping line %d of %s: %v", n, name, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return entries, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return entries, nil
+}
+
+// systemUptime はOSが起動してからの時間を返す
+func systemUptime() (time.Duration, error) {
+	data, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected /proc/uptime: %q", data)
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
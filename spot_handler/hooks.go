@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// 組み込みの処理 (フックの action に指定できる)
+var builtinHookActions = []string{"countdown", "save", "stop", "wait", "backup", "script", "notify", "publish"}
+
+// HookConfig は中断時に実行するフック1つ分の設定
+// action (組み込みの処理) か command (外部コマンド) のどちらか、または parallel (並列グループ) を指定する
+type HookConfig struct {
+	// ログや状態ファイルで使う名前。省略すると action の名前になる
+	Name string `yaml:"name"`
+	// 組み込みの処理 (countdown, save, stop, wait, backup, script, notify, publish)
+	Action string `yaml:"action"`
+	// 実行するコマンドと引数
+	Command string   `yaml:"command"`
+	Args    []string `yaml:"args"`
+	// コマンドに追加で渡す環境変数
+	Env map[string]string `yaml:"env"`
+	// タイムアウト。省略すると stepTimeouts の値 (コマンドは script の値) を使う
+	Timeout string `yaml:"timeout"`
+	// 失敗しても後続のフックを実行するか (デフォルト true)
+	ContinueOnFailure *bool `yaml:"continueOnFailure"`
+	// 並列に実行するフックのグループ
+	Parallel []HookConfig `yaml:"parallel"`
+
+	timeout time.Duration
+}
+
+// defaultHooks は hooks を設定しなかった場合のフック
+// カウントダウン → save-all flush → stop → Javaプロセスの終了待ち → バックアップ → スクリプト → Discord通知 → 中断イベントの送信
+// 中断イベントにはこのシャットダウンで取ったバックアップを載せるため、最後に送る
+func defaultHooks() []HookConfig {
+	hooks := make([]HookConfig, 0, len(builtinHookActions))
+	for _, action := range builtinHookActions {
+		hooks = append(hooks, HookConfig{Name: action, Action: action})
+	}
+	return hooks
+}
+
+// parseHooks はフックの設定を検証し、名前とタイムアウトを埋める
+func parseHooks(hooks []HookConfig, nested bool) error {
+	for i := range hooks {
+		h := &hooks[i]
+		if len(h.Parallel) > 0 {
+			if nested {
+				return fmt.Errorf("hooks[%d]: parallel groups cannot be nested", i)
+			}
+			if h.Action != "" || h.Command != "" {
+				return fmt.Errorf("hooks[%d]: parallel cannot be combined with action or command", i)
+			}
+			if err := parseHooks(h.Parallel, true); err != nil {
+				return fmt.Errorf("hooks[%d].parallel: %w", i, err)
+			}
+			continue
+		}
+
+		switch {
+		case h.Action != "" && h.Command != "":
+			return fmt.Errorf("hooks[%d]: action and command cannot be used together", i)
+		case h.Action != "":
+			if !slices.Contains(builtinHookActions, h.Action) {
+				return fmt.Errorf("hooks[%d]: unknown action %q", i, h.Action)
+			}
+			if len(h.Args) > 0 || len(h.Env) > 0 {
+				return fmt.Errorf("hooks[%d]: args and env are only supported for command hooks", i)
+			}
+			if h.Name == "" {
+				h.Name = h.Action
+			}
+		case h.Command != "":
+			if h.Name == "" {
+				return fmt.Errorf("hooks[%d]: command hooks need a name", i)
+			}
+		default:
+			return fmt.Errorf("hooks[%d]: one of action, command or parallel is required", i)
+		}
+
+		if h.Timeout != "" {
+			d, err := time.ParseDuration(h.Timeout)
+			if err != nil {
+				return fmt.Errorf("hooks[%d]: invalid timeout: %w", i, err)
+			}
+			h.timeout = d
+		}
+	}
+	return nil
+}
+
+// checkHookNames はフック名が重複していないか確認する (状態ファイルでの再開に名前を使うため)
+func checkHookNames(hooks []HookConfig) error {
+	seen := make(map[string]bool)
+	for _, h := range hooks {
+		group := h.Parallel
+		if len(group) == 0 {
+			group = []HookConfig{h}
+		}
+		for _, g := range group {
+			if seen[g.Name] {
+				return fmt.Errorf("duplicate hook name %q", g.Name)
+			}
+			seen[g.Name] = true
+		}
+	}
+	return nil
+}
+
+// hookSteps はフックの設定をパイプラインのステップにする
+func (r *shutdownRun) hookSteps(hooks []HookConfig, p *Pipeline, countdownEnd time.Time) []Step {
+	steps := make([]Step, 0, len(hooks))
+	for _, h := range hooks {
+		if len(h.Parallel) > 0 {
+			steps = append(steps, Step{Parallel: r.hookSteps(h.Parallel, p, countdownEnd)})
+			continue
+		}
+
+		step := Step{
+			Name:          h.Name,
+			Timeout:       h.timeout,
+			StopOnFailure: h.ContinueOnFailure != nil && !*h.ContinueOnFailure,
+		}
+		switch h.Action {
+		case "countdown":
+			step.Run = func(ctx context.Context) error { return r.countdown(ctx, countdownEnd) }
+			if step.Timeout == 0 {
+				// カウントダウン自体の長さに余裕を加える
+				step.Timeout = time.Until(countdownEnd) + r.config.stepTimeout("countdown")
+			}
+		case "save":
+			step.Run = r.save
+		case "stop":
+			step.Run = r.stop
+		case "wait":
+			step.Run = r.wait
+		case "backup":
+			step.Run = r.backup
+		case "script":
+			step.Run = r.script
+		case "notify":
+			step.Run = func(ctx context.Context) error { return r.notify(ctx, p) }
+			// 中止された場合も結果を通知する
+			step.Always = true
+		case "publish":
+			step.Run = func(ctx context.Context) error { return r.publish(ctx, p) }
+			// 中止された場合も置き換えのインスタンスは必要
+			step.Always = true
+			// 前のステップで中断時刻まで使い切っても、インスタンスが止まるまでの間に送る
+			step.IgnoreDeadline = true
+		case "":
+			hook := h
+			step.Run = func(ctx context.Context) error { return r.command(ctx, hook) }
+		}
+		if step.Timeout == 0 {
+			name := h.Action
+			if name == "" {
+				name = "script"
+			}
+			step.Timeout = r.config.stepTimeout(name)
+		}
+		if r.simulate != "" {
+			step.Run = r.simulatedStep(h, step.Run)
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// command はコマンドのフックを実行する
+// 中断の内容 (SPOT_*) とRCONの接続先、フックの env を環境変数で渡す
+func (r *shutdownRun) command(ctx context.Context, hook HookConfig) error {
+	env := append(r.action.Env(time.Now()), r.config.RCON.Env()...)
+	keys := make([]string, 0, len(hook.Env))
+	for k := range hook.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, k+"="+hook.Env[k])
+	}
+	return runLabeledCommand(ctx, hook.Name, hook.Command, hook.Args, env)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"common/imds"
+)
+
+// スポットインスタンスの中断アクション
+const (
+	ActionTerminate = "terminate"
+	ActionStop      = "stop"
+	ActionHibernate = "hibernate"
+)
+
+// InstanceAction は spot/instance-action が返す中断通知です。
+// 例: {"action": "terminate", "time": "2017-09-18T08:22:00Z"}
+type InstanceAction struct {
+	Action string    `json:"action"`
+	Time   time.Time `json:"time"`
+
+	// ID はクラウドが付けた中断通知のID (Azure の EventId)。空の場合は Action と Time で識別する
+	ID string `json:"-"`
+	// Notice は通知に中断時刻が含まれない場合 (GCP) の、検知から中断までの時間
+	// Time は検知した時刻からの見積もりのため、handlerState.identify で最初に検知した時刻に揃える
+	Notice time.Duration `json:"-"`
+}
+
+// newInstanceAction はIMDSから取得した中断通知の内容を検証します。
+func newInstanceAction(spotAction *imds.SpotAction) (*InstanceAction, error) {
+	action := InstanceAction{Action: spotAction.Action, Time: spotAction.Time}
+	switch action.Action {
+	case ActionTerminate, ActionStop, ActionHibernate:
+	default:
+		return nil, fmt.Errorf("unknown instance action: %q", action.Action)
+	}
+	if action.Time.IsZero() {
+		return nil, fmt.Errorf("instance-action has no time")
+	}
+	return &action, nil
+}
+
+// TimeLeft は中断時刻までの残り時間を返します。既に過ぎている場合は0です。
+func (a *InstanceAction) TimeLeft(now time.Time) time.Duration {
+	left := a.Time.Sub(now)
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// Env はシャットダウンスクリプトに渡す環境変数を返します。
+func (a *InstanceAction) Env(now time.Time) []string {
+	return []string{
+		"SPOT_EVENT=interruption",
+		"SPOT_ACTION=" + a.Action,
+		"SPOT_DEADLINE=" + a.Time.UTC().Format(time.RFC3339),
+		"SPOT_DEADLINE_UNIX=" + strconv.FormatInt(a.Time.Unix(), 10),
+		"SPOT_TIME_LEFT_SECONDS=" + strconv.Itoa(int(a.TimeLeft(now).Seconds())),
+	}
+}
@@ -1,24 +1,187 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"common/countdown"
+	"common/imds"
+	"common/sdnotify"
 )
 
 type Config struct {
 	PollingInterval string `yaml:"pollingInterval"`
 	ShutdownScript  string `yaml:"shutdownScript"`
-	MetadataURL     string `yaml:"metadataUrl"`
+	// 中断通知を取得するクラウド (aws, gcp, azure)
+	Provider string           `yaml:"provider"`
+	IMDS     IMDSConfig       `yaml:"imds"`
+	GCP      MetadataEndpoint `yaml:"gcp"`
+	Azure    MetadataEndpoint `yaml:"azure"`
+	// リバランス推奨 (中断の早期警告) をチェックするか
+	CheckRebalance     bool   `yaml:"checkRebalance"`
+	EarlyWarningScript string `yaml:"earlyWarningScript"`
+	// アクション (terminate, stop, hibernate) ごとのスクリプト。未設定のアクションは ShutdownScript を使う
+	ActionScripts map[string]string `yaml:"actionScripts"`
+
+	MinecraftService  string     `yaml:"minecraftService"`
+	RCON              RCONConfig `yaml:"rcon"`
+	DiscordWebhookURL string     `yaml:"discordWebhookUrl"`
+	BackupCommand     string     `yaml:"backupCommand"`
+	// 処理済みの中断通知と完了したステップを記録するファイル
+	StateFile string `yaml:"stateFile"`
+	// ステップごとのタイムアウト (例: save: "30s")。未設定のステップはデフォルト値を使う
+	StepTimeouts map[string]string `yaml:"stepTimeouts"`
+	Countdown    CountdownConfig   `yaml:"countdown"`
+	// 中断時に実行するフック。省略するとデフォルトの順番で組み込みの処理を実行する
+	Hooks []HookConfig `yaml:"hooks"`
+	// EC2の予定メンテナンス (再起動やリタイア) の通知
+	Maintenance MaintenanceConfig `yaml:"maintenance"`
+	// ヘルスチェックとメトリクスを返すHTTPサーバー
+	Status StatusConfig `yaml:"status"`
+	// 中断通知の取得に失敗し続けた場合のバックオフと通知
+	PollFailures PollFailureConfig `yaml:"pollFailures"`
+	// シャットダウン処理の最後に送る中断イベント
+	Failover FailoverConfig `yaml:"failover"`
+	// 中断されそうなときに保存の間隔を短くする高リスクモード
+	HighRisk HighRiskConfig `yaml:"highRisk"`
+	// 中断、リバランス推奨、メンテナンスの履歴
+	History HistoryConfig `yaml:"history"`
+
+	// Deprecated: imds.baseUrl を使う。古い設定ファイルを読めるよう、imds.baseUrl に読み替える
+	MetadataURL string `yaml:"metadataUrl"`
+	// Deprecated: checkRebalance を使う。設定されていればリバランス推奨をチェックする
+	RebalanceURL string `yaml:"rebalanceUrl"`
+
+	pollingInterval time.Duration
+	stepTimeouts    map[string]time.Duration
+}
+
+// CountdownConfig は停止前にゲーム内に表示するカウントダウンの設定
+type CountdownConfig struct {
+	// 表示するタイミング (stopの何秒前か)。例: ["90s", "60s", "30s", "10s"]
+	Points []string `yaml:"points"`
+	// メッセージの言語 (ja または en)
+	Language string `yaml:"language"`
+	// 中断時刻の何秒前にカウントダウンを終えて保存・停止に移るか
+	StopMargin string `yaml:"stopMargin"`
+
+	points     []time.Duration
+	stopMargin time.Duration
+}
+
+// StatusConfig は /healthz, /status, /metrics を返すHTTPサーバーの設定
+type StatusConfig struct {
+	// 待ち受けるアドレス (例: "127.0.0.1:9108")。空の場合はサーバーを起動しない
+	Listen string `yaml:"listen"`
+}
+
+// MetadataEndpoint はGCPやAzureのメタデータサーバーの接続先
+type MetadataEndpoint struct {
+	// メタデータサーバーのURL。空の場合は各クラウドのデフォルト。ローカルのエミュレーターでテストする場合に変更する
+	BaseURL string `yaml:"baseUrl"`
+}
+
+// IMDSConfig はインスタンスメタデータサービスへの接続設定
+type IMDSConfig struct {
+	// IMDSのURL。ローカルのエミュレーターでテストする場合に変更する
+	BaseURL string `yaml:"baseUrl"`
+	// IMDSv2トークンの有効期間 (最大6h)
+	TokenTTL string `yaml:"tokenTtl"`
+	// ネットワークエラーや5xxのときのリトライ回数
+	Retries *int `yaml:"retries"`
+
+	tokenTTL time.Duration
+}
+
+// newClient は設定に従ってIMDSクライアントを作成する
+func (c IMDSConfig) newClient() *imds.Client {
+	opts := []imds.Option{
+		imds.WithBaseURL(c.BaseURL),
+		imds.WithTokenTTL(c.tokenTTL),
+		imds.WithHooks(imds.Hooks{Request: stats.observeIMDS, TokenRefresh: stats.observeTokenRefresh}),
+	}
+	if c.Retries != nil {
+		opts = append(opts, imds.WithRetries(*c.Retries, 0))
+	}
+	return imds.New(opts...)
+}
+
+type RCONConfig struct {
+	Host         string `yaml:"host"`
+	Port         int    `yaml:"port"`
+	PasswordFile string `yaml:"passwordFile"`
+}
+
+// Env はバックアップツールなど外部コマンドにRCONの接続先を渡すための環境変数を返す
+// パスワードそのものではなくファイルのパスを渡す
+func (r RCONConfig) Env() []string {
+	return []string{
+		"RCON_HOST=" + r.Host,
+		"RCON_PORT=" + strconv.Itoa(r.Port),
+		"RCON_PASSWORD_FILE=" + r.PasswordFile,
+	}
+}
+
+// stepTimeout はパイプラインのステップのタイムアウトを返す
+func (c *Config) stepTimeout(step string) time.Duration {
+	if d, ok := c.stepTimeouts[step]; ok {
+		return d
+	}
+	if d, ok := defaultStepTimeouts[step]; ok {
+		return d
+	}
+	return 30 * time.Second
+}
+
+// shutdownScriptFor は中断アクションに対応するスクリプトを返します。
+func (c *Config) shutdownScriptFor(action string) string {
+	if script, ok := c.ActionScripts[action]; ok && script != "" {
+		return script
+	}
+	return c.ShutdownScript
+}
+
+// migrateLegacyURLs は以前の metadataUrl と rebalanceUrl を imds.baseUrl と checkRebalance に読み替える
+// 以前はエンドポイントのURL (http://169.254.169.254/latest/meta-data/spot/instance-action など) を書いていたため、
+// /latest/ より前の部分をIMDSのURLとして使う
+func (c *Config) migrateLegacyURLs() error {
+	for _, legacy := range []struct{ name, url string }{
+		{"metadataUrl", c.MetadataURL},
+		{"rebalanceUrl", c.RebalanceURL},
+	} {
+		if legacy.url == "" {
+			continue
+		}
+		u, err := url.Parse(legacy.url)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid %s: %q", legacy.name, legacy.url)
+		}
+		if c.IMDS.BaseURL == "" {
+			base, _, _ := strings.Cut(u.Path, "/latest/")
+			c.IMDS.BaseURL = u.Scheme + "://" + u.Host + strings.TrimSuffix(base, "/")
+		}
+		if legacy.name == "rebalanceUrl" {
+			c.CheckRebalance = true
+			log.Printf("Warning: rebalanceUrl is deprecated. Using imds.baseUrl %q and checkRebalance: true instead.", c.IMDS.BaseURL)
+		} else {
+			log.Printf("Warning: metadataUrl is deprecated. Using imds.baseUrl %q instead.", c.IMDS.BaseURL)
+		}
+	}
+	return nil
 }
 
 func loadConfig(path string) (*Config, error) {
@@ -27,79 +190,184 @@ func loadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
+	// 書き間違えたキーが黙って無視されないよう、知らないキーはエラーにする
 	var config Config
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
 		return nil, fmt.Errorf("failed to unmarshal config yaml: %w", err)
 	}
 
-	return &config, nil
-}
-
-// --- 中断検知関数 ---
-// trueを返した場合、中断通知があったことを示す
-func checkInterruption(url string) (bool, error) {
-	token, err := getIMDSv2Token()
+	if config.PollingInterval == "" {
+		config.PollingInterval = defaultPollingInterval
+	}
+	config.pollingInterval, err = time.ParseDuration(config.PollingInterval)
 	if err != nil {
-		log.Printf("Could not get IMDSv2 token: %v. Proceeding without token", err)
+		return nil, fmt.Errorf("invalid pollingInterval: %w", err)
+	}
+	if config.pollingInterval <= 0 {
+		return nil, fmt.Errorf("pollingInterval must be positive: %s", config.PollingInterval)
 	}
 
-	// タイムアウトを設定したHTTPクライアントを作成
-	client := &http.Client{
-		Timeout: 2 * time.Second,
+	if config.MinecraftService == "" {
+		config.MinecraftService = "minecraft.service"
 	}
-	
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return false, fmt.Errorf("failed to create metadata request: %w", err)
+	if config.RCON.Host == "" {
+		config.RCON.Host = "127.0.0.1"
+	}
+	if config.RCON.Port == 0 {
+		config.RCON.Port = 25575
+	}
+	if config.StateFile == "" {
+		config.StateFile = defaultStateFile
+	}
+	if config.History.File == "" {
+		config.History.File = defaultHistoryFile
+	}
+	if config.History.S3Prefix == "" {
+		config.History.S3Prefix = defaultHistoryS3Prefix
+	}
+	switch config.Provider {
+	case "":
+		config.Provider = providerAWS
+	case providerAWS, providerGCP, providerAzure:
+	default:
+		return nil, fmt.Errorf("unknown provider: %q (must be aws, gcp or azure)", config.Provider)
+	}
+	if err := config.migrateLegacyURLs(); err != nil {
+		return nil, err
+	}
+	if config.IMDS.BaseURL == "" {
+		config.IMDS.BaseURL = imds.DefaultBaseURL
+	}
+	if config.GCP.BaseURL == "" {
+		config.GCP.BaseURL = defaultGCPMetadataURL
+	}
+	if config.Azure.BaseURL == "" {
+		config.Azure.BaseURL = defaultAzureMetadataURL
+	}
+	if config.IMDS.TokenTTL != "" {
+		config.IMDS.tokenTTL, err = time.ParseDuration(config.IMDS.TokenTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid imds tokenTtl: %w", err)
+		}
+		if config.IMDS.tokenTTL < time.Second || config.IMDS.tokenTTL > 6*time.Hour {
+			return nil, fmt.Errorf("imds tokenTtl must be between 1s and 6h: %s", config.IMDS.TokenTTL)
+		}
+	}
+	config.stepTimeouts = make(map[string]time.Duration)
+	for step, value := range config.StepTimeouts {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout for step %s: %w", step, err)
+		}
+		config.stepTimeouts[step] = d
+	}
+
+	if len(config.Countdown.Points) > 0 {
+		config.Countdown.points, err = countdown.ParsePoints(strings.Join(config.Countdown.Points, ","))
+		if err != nil {
+			return nil, fmt.Errorf("invalid countdown points: %w", err)
+		}
+	}
+	if config.Countdown.Language == "" {
+		config.Countdown.Language = "ja"
+	}
+	config.Countdown.stopMargin = defaultStopMargin
+	if config.Countdown.StopMargin != "" {
+		config.Countdown.stopMargin, err = time.ParseDuration(config.Countdown.StopMargin)
+		if err != nil {
+			return nil, fmt.Errorf("invalid countdown stopMargin: %w", err)
+		}
+	}
+
+	if len(config.Hooks) == 0 {
+		config.Hooks = defaultHooks()
 	}
-	if token != "" {
-		req.Header.Set("X-aws-ec2-metadata-token", token)
+	if err := parseHooks(config.Hooks, false); err != nil {
+		return nil, fmt.Errorf("invalid hooks: %w", err)
+	}
+	if err := checkHookNames(config.Hooks); err != nil {
+		return nil, fmt.Errorf("invalid hooks: %w", err)
 	}
 
-	resp, err := client.Do(req)
+	if err := config.Maintenance.parse(); err != nil {
+		return nil, err
+	}
+	if err := config.PollFailures.parse(); err != nil {
+		return nil, err
+	}
+	if err := config.HighRisk.parse(); err != nil {
+		return nil, err
+	}
+
+	// リバランス推奨とメンテナンスイベントはEC2にしかない
+	if config.Provider != providerAWS && (config.CheckRebalance || config.Maintenance.Enabled) {
+		log.Printf("checkRebalance and maintenance are only supported on aws. Ignoring them for provider %s.", config.Provider)
+		config.CheckRebalance = false
+		config.Maintenance.Enabled = false
+	}
+
+	return &config, nil
+}
+
+// --- 中断検知関数 ---
+// 中断通知があった場合は内容を返す。なければnilを返す
+// 中断時刻を含まない通知は、state に保存した最初に検知した時刻で識別する
+func checkInterruption(ctx context.Context, provider interruptionProvider, state *handlerState) (*InstanceAction, error) {
+	action, err := provider.Check(ctx)
 	if err != nil {
-		// ネットワークエラーなど
-		return false, fmt.Errorf("failed to get metadata: %w", err)
-	}
-	defer resp.Body.Close()
-
-	switch resp.StatusCode {
-	case http.StatusOK:
-		// 200 OK: 中断通知あり
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("Interruption notice received. Details: %s", string(body))
-		return true, nil
-	case http.StatusNotFound:
-		// 404 Not Found: 正常、中断なし
+		return nil, err
+	}
+	if action == nil {
 		log.Println("No interruption notice. Continuing to poll.")
-		return false, nil
-	case http.StatusUnauthorized:
-		log.Println("Error: 401 Unauthorized/ IMDSv2 token is likely required or invalid.")
-		return false, fmt.Errorf("unauthorized access to metadata service(status 401)")
-	default:
-		// その他のステータスコードは予期せぬエラー
-		return false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, nil
+	}
+	if state.identify(action, time.Now()) {
+		state.saveOrLog()
 	}
+	log.Printf("Interruption notice received. Action: %s, Time: %s (%s left)",
+		action.Action, action.Time.Format(time.RFC3339), action.TimeLeft(time.Now()).Round(time.Second))
+	return action, nil
 }
 
-// --- シャットダウン処理実行関数 ---
-func executeShutdownScript(scriptPath string) {
-	log.Printf("Executing shutdown script: %s", scriptPath)
-	// コマンドを実行
-	cmd := exec.Command("/bin/sh", scriptPath)
-
-	// コマンドの標準出力と標準エラーを取得
-	output, err := cmd.CombinedOutput()
+// --- リバランス推奨検知関数 ---
+// 推奨通知があった場合は内容を返す。なければnilを返す
+func checkRebalance(ctx context.Context, md *imds.Client) (*imds.Rebalance, error) {
+	rec, err := md.RebalanceRecommendation(ctx)
 	if err != nil {
-		log.Printf("Error executing shutdown script: %v. Output: %s", err, string(output))
-		return
+		return nil, fmt.Errorf("failed to get rebalance recommendation: %w", err)
 	}
-	log.Printf("Shutdown script executed successfully. Output: %s", string(output))
+	return rec, nil
+}
+
+// 設定ファイルのデフォルトの場所とポーリング間隔
+const (
+	defaultConfigPath      = "/etc/spot-handler/config.yaml"
+	defaultPollingInterval = "5s"
+)
+
+// subcommands はハンドラーとして起動する代わりに実行するサブコマンド
+var subcommands = map[string]func(args []string) error{
+	"report":   runReport,
+	"validate": runValidate,
 }
 
 func main() {
-	configPath := flag.String("config", "/etc/spot-handler/config.yaml", "Path to the configuration file")
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatalf("Fatal: %v", err)
+			}
+			return
+		}
+	}
+
+	configPath := flag.String("config", defaultConfigPath, "Path to the configuration file")
+	var simulate simulateFlag
+	flag.Var(&simulate, "simulate", "Run the shutdown pipeline without side effects and exit (dry-run, or live-notify to also send the notifications)")
+	simulateAction := flag.String("action", ActionTerminate, "Interruption action to simulate (terminate, stop or hibernate)")
+	simulateDeadline := flag.Duration("deadline", 2*time.Minute, "Time until the simulated interruption")
 	flag.Parse()
 
 	log.Println("Starting Spot Interruption Handler...")
@@ -109,75 +377,208 @@ func main() {
 	if err != nil {
 		log.Fatalf("Fatal: Could not load config from %s. %v", *configPath, err)
 	}
-	log.Printf("Config loaded: Polling every %s", config.PollingInterval)
+	log.Printf("Config loaded: Polling every %s", config.pollingInterval)
+
+	if simulate.mode != "" {
+		if err := runSimulation(config, simulate.mode, *simulateAction, *simulateDeadline); err != nil {
+			log.Fatalf("Fatal: %v", err)
+		}
+		return
+	}
 
-	// 2. ポーリング間隔をパース
-	duration, err := time.ParseDuration(config.PollingInterval)
+	// 再起動前に処理した中断通知とメンテナンスイベントを読み込む
+	state, err := loadState(config.StateFile)
 	if err != nil {
-		log.Fatalf("Fatal: Invalid polling interval format. %v", err)
+		log.Printf("Could not load handler state: %v. Starting with an empty state.", err)
 	}
-	ticker := time.NewTicker(duration)
+
+	// 2. メタデータのクライアントと監視を作成する
+	w := newWatchers(config, state)
+	defer w.close()
+
+	if config.Status.Listen != "" {
+		server := startStatusServer(config.Status.Listen)
+		defer server.Close()
+	}
+
+	ticker := time.NewTicker(config.pollingInterval)
 	defer ticker.Stop()
 
+	// systemd (Type=notify) に起動完了を知らせる
+	// ウォッチドッグはポーリングのループから送るため、ループが止まると systemd が再起動する
+	if err := sdnotify.Ready(pollingStatus(config, w.provider)); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+	wd := newWatchdog()
+	watchdogTick, stopWatchdog := wd.tick()
+	defer stopWatchdog()
+
 	// 3. OSのシグナルを待機するチャンネルを作成
 	// SIGINT (Ctrl+C) や SIGTERM (killコマンド) を受け取ったら終了する
+	// SIGHUP を受け取ったら設定ファイルを読み直す
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	// リバランス推奨の早期警告を実行済みかどうか
+	// 推奨通知は一度出ると消えないため、ポーリングのたびに実行しないよう記録しておく
+	rebalanceNotified := false
+	// 実行中の早期警告
+	var warning *earlyWarning
+	// finishEarlyWarning は早期警告の結果を履歴と状態に記録する
+	finishEarlyWarning := func(p *Pipeline) {
+		w.history.record(historyEntry{Event: historyRebalance, Time: warning.rec.NoticeTime,
+			PipelineSeconds: time.Since(warning.started).Seconds(), PipelineFailed: p.Failed()})
+		state.RebalanceNoticeTime = warning.rec.NoticeTime
+		state.saveOrLog()
+		warning = nil
+	}
+
+	// 中断通知の取得の連続失敗
+	failures := &pollFailures{config: config}
+	interval := config.pollingInterval
+
+	// 同じアベイラビリティーゾーンで中断が続いている場合は高リスクモードで始める
+	risk := newRiskMode(config)
+	defer risk.stop()
+	risk.checkZoneHistory(availabilityZone(context.Background(), w.provider))
 
 	// 4. メインループ
 	for {
 		select {
 		case <-ticker.C:
 			// 定期的なポーリング処理
-			interrupted, err := checkInterruption(config.MetadataURL)
+			ctx := context.Background()
+			if !rebalanceNotified && config.CheckRebalance {
+				rec, err := checkRebalance(ctx, w.md)
+				switch {
+				case err != nil:
+					log.Printf("Error checking for rebalance recommendation: %v", err)
+				case rec != nil && rec.NoticeTime.Equal(state.RebalanceNoticeTime):
+					// 再起動前に早期警告を実行済み
+					log.Printf("Rebalance recommendation (noticeTime: %s) was already handled before restart.", rec.NoticeTime.Format(time.RFC3339))
+					rebalanceNotified = true
+					setPhase(phaseWarned, "Rebalance recommendation received. Polling for interruptions.")
+					risk.raise("rebalance recommendation", rec.NoticeTime)
+				case rec != nil:
+					// 早期警告には数分かかることがあるため、その間も中断通知のポーリングを続ける
+					log.Printf("Rebalance recommendation received (noticeTime: %s). Running early warning.", rec.NoticeTime.Format(time.RFC3339))
+					setPhase(phaseWarned, "Rebalance recommendation received. Running early warning.")
+					warning = startEarlyWarning(config, w.md, rec)
+					rebalanceNotified = true
+					risk.raise("rebalance recommendation", rec.NoticeTime)
+				}
+			}
+
+			action, err := checkInterruption(ctx, w.provider, state)
+			stats.observePoll(action != nil, err)
+			// ネットワークエラーや5xxが続く場合は間隔を延ばす
+			next := failures.observe(ctx, err)
+			if next != interval {
+				interval = next
+				ticker.Reset(interval)
+			}
 			if err != nil {
 				// エラーが発生しても処理は継続する
-				log.Printf("Error checking for interruption: %v", err)
+				log.Printf("Error checking for interruption: %v. Next poll in %s.", err, next.Round(time.Millisecond))
 				continue
 			}
 
-			if interrupted {
-				// 中断を検知したらスクリプトを実行して終了
-				executeShutdownScript(config.ShutdownScript)
+			if action != nil {
+				// 中断を検知したらシャットダウン処理を実行して終了
+				// 再起動前に処理を終えていた場合は繰り返さない
+				if state.handled(action) {
+					log.Printf("Interruption %s was already handled at %s. Skipping the shutdown.",
+						state.Interruption.Key, state.Interruption.FinishedAt.Format(time.RFC3339))
+				} else {
+					if warning != nil {
+						log.Println("Interruption received during the early warning. Cancelling it.")
+						finishEarlyWarning(warning.abort())
+					}
+					// シャットダウン処理でも保存するため、高リスクモードの保存は止める
+					risk.leave("interruption received")
+					setPhase(phaseInterrupting, fmt.Sprintf("Interruption received (%s at %s). Running shutdown.", action.Action, action.Time.Format(time.RFC3339)))
+					stopPings := wd.keepAlive(shutdownDeadline(action).Add(watchdogGrace))
+					// インスタンスが止まる前にS3へコピーできるよう、中断はシャットダウン処理と並行して検知した時点で記録する
+					// 再起動して処理を再開した場合は記録済み
+					recorded := make(chan struct{})
+					if resuming := state.Interruption != nil && state.Interruption.Key == interruptionKey(action); resuming {
+						close(recorded)
+					} else {
+						go func() {
+							defer close(recorded)
+							w.history.record(historyEntry{Event: historyInterruption, Time: action.Time, Detail: action.Action})
+						}()
+					}
+					p := runShutdownPipeline(config, w.provider, action, state)
+					stopPings()
+					<-recorded
+					rec := state.Interruption
+					w.history.record(historyEntry{Event: historyShutdown, Time: action.Time, Detail: action.Action,
+						PipelineSeconds: rec.FinishedAt.Sub(rec.StartedAt).Seconds(), PipelineFailed: p.Failed()})
+				}
+				setPhase(phaseDone, "Interruption handled. Exiting.")
+				ackCtx, cancel := context.WithTimeout(context.Background(), config.stepTimeout("notify"))
+				if err := w.provider.Acknowledge(ackCtx, action); err != nil {
+					log.Printf("Failed to acknowledge the interruption: %v", err)
+				}
+				cancel()
 				log.Println("Handler finished its job. Exiting.")
 				return
 			}
 
+		case p := <-warning.finished():
+			finishEarlyWarning(p)
+			notifyStatus("Rebalance recommendation received. Polling for interruptions.")
+
+		case <-watchdogTick:
+			wd.ping()
+
+		case <-w.maintenanceTick():
+			w.pollMaintenance()
+
+		case <-risk.tick():
+			risk.save()
+
 		case sig := <-sigChan:
+			if sig == syscall.SIGHUP {
+				// 設定ファイルを読み直す。不正な場合は今の設定のまま続ける
+				// 処理済みの中断通知や早期警告の状態は引き継ぐ
+				log.Printf("Received signal: %s. Reloading config from %s.", sig, *configPath)
+				sdnotify.Notify(sdnotify.StateReloading)
+				newConfig, err := reloadConfig(*configPath, config)
+				if err != nil {
+					log.Printf("Failed to reload config: %v. Keeping the current config.", err)
+				} else {
+					config = newConfig
+					w.apply(config)
+					failures.config = config
+					risk.reload(config)
+					interval = config.pollingInterval
+					ticker.Reset(interval)
+					if state.path != config.StateFile {
+						log.Printf("State file changed to %s.", config.StateFile)
+						state.path = config.StateFile
+						state.saveOrLog()
+					}
+					log.Printf("Config reloaded: Polling every %s", config.pollingInterval)
+				}
+				if err := sdnotify.Ready(pollingStatus(config, w.provider)); err != nil {
+					log.Printf("Failed to notify systemd: %v", err)
+				}
+				continue
+			}
 			// OSからの終了シグナルを受け取った場合
 			log.Printf("Received signal: %s. Shutting down.", sig)
+			sdnotify.Notify(sdnotify.StateStopping)
+			if warning != nil {
+				warning.abort()
+			}
 			return
 		}
 	}
 }
 
-func getIMDSv2Token() (string, error) {
-	client := &http.Client{
-		Timeout: 2 * time.Second,
-	}
-
-	// トークン取得用のPUTリクエストを作成
-	req, err := http.NewRequest("PUT", "http://169.254.169.254/latest/api/token", nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create token request: %w", err)
-	}
-	// ヘッダーにTTL（トークンの有効期間）を設定
-	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600") // 6 hours
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to get IMDSv2 token: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to get IMDSv2 token, status code: %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read token response body: %w", err)
-	}
-
-	return string(body), nil
+// pollingStatus は systemctl status に表示するポーリング中の状態を返す
+func pollingStatus(config *Config, provider interruptionProvider) string {
+	return fmt.Sprintf("Polling every %s (%s)", config.pollingInterval, provider.Name())
 }
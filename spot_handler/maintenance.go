@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"common/imds"
+)
+
+// メンテナンスイベントのポーリング間隔などのデフォルト
+// イベントは数日前に通知されるため、中断通知ほど頻繁にチェックする必要はない
+const (
+	defaultMaintenancePollingInterval = 5 * time.Minute
+	defaultMaintenanceHookLeadTime    = 10 * time.Minute
+)
+
+var defaultMaintenanceAnnounceBefore = []time.Duration{24 * time.Hour, time.Hour, 10 * time.Minute}
+
+// MaintenanceConfig はEC2の予定メンテナンス (再起動やリタイア) の設定
+type MaintenanceConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// events/maintenance/scheduled をチェックする間隔
+	PollingInterval string `yaml:"pollingInterval"`
+	// NotBefore の何時間前にゲーム内でお知らせするか。例: ["24h", "1h", "10m"]
+	AnnounceBefore []string `yaml:"announceBefore"`
+	// NotBefore の少し前に実行するスクリプト
+	HookScript string `yaml:"hookScript"`
+	// NotBefore のどれだけ前にスクリプトを実行するか
+	HookLeadTime string `yaml:"hookLeadTime"`
+
+	pollingInterval time.Duration
+	announceBefore  []time.Duration
+	hookLeadTime    time.Duration
+}
+
+// parse は文字列で書かれた時間をパースし、未設定の項目にデフォルト値を入れる
+func (c *MaintenanceConfig) parse() error {
+	var err error
+	c.pollingInterval = defaultMaintenancePollingInterval
+	if c.PollingInterval != "" {
+		if c.pollingInterval, err = time.ParseDuration(c.PollingInterval); err != nil {
+			return fmt.Errorf("invalid maintenance pollingInterval: %w", err)
+		}
+	}
+	c.hookLeadTime = defaultMaintenanceHookLeadTime
+	if c.HookLeadTime != "" {
+		if c.hookLeadTime, err = time.ParseDuration(c.HookLeadTime); err != nil {
+			return fmt.Errorf("invalid maintenance hookLeadTime: %w", err)
+		}
+	}
+	c.announceBefore = defaultMaintenanceAnnounceBefore
+	if c.AnnounceBefore != nil {
+		c.announceBefore = nil
+		for _, value := range c.AnnounceBefore {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid maintenance announceBefore %q: %w", value, err)
+			}
+			c.announceBefore = append(c.announceBefore, d)
+		}
+	}
+	return nil
+}
+
+// maintenanceEvent はパース済みのメンテナンスイベント
+type maintenanceEvent struct {
+	ID          string
+	Code        string
+	Description string
+	NotBefore   time.Time
+	NotAfter    time.Time
+}
+
+// Env はフックスクリプトに渡す環境変数を返す
+func (e maintenanceEvent) Env() []string {
+	env := []string{
+		"SPOT_EVENT=maintenance",
+		"MAINTENANCE_EVENT_ID=" + e.ID,
+		"MAINTENANCE_CODE=" + e.Code,
+		"MAINTENANCE_NOT_BEFORE=" + e.NotBefore.UTC().Format(time.RFC3339),
+	}
+	if !e.NotAfter.IsZero() {
+		env = append(env, "MAINTENANCE_NOT_AFTER="+e.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return env
+}
+
+// window はメンテナンスの時間帯を表示用の文字列にする
+func (e maintenanceEvent) window(loc *time.Location) string {
+	const layout = "2006-01-02 15:04"
+	start := e.NotBefore.In(loc).Format(layout)
+	if e.NotAfter.IsZero() {
+		return start + " " + e.NotBefore.In(loc).Format("MST")
+	}
+	return start + " - " + e.NotAfter.In(loc).Format(layout) + " " + e.NotBefore.In(loc).Format("MST")
+}
+
+// parseMaintenanceEvent はIMDSのイベントをパースする
+// 完了・キャンセル済みのイベントは ok=false を返す
+func parseMaintenanceEvent(ev imds.ScheduledEvent) (maintenanceEvent, bool, error) {
+	state := strings.ToLower(ev.State)
+	if state == "completed" || state == "canceled" ||
+		strings.HasPrefix(ev.Description, "[Completed]") || strings.HasPrefix(ev.Description, "[Canceled]") {
+		return maintenanceEvent{}, false, nil
+	}
+
+	notBefore, err := imds.ParseEventTime(ev.NotBefore)
+	if err != nil {
+		return maintenanceEvent{}, false, err
+	}
+	if notBefore.IsZero() {
+		return maintenanceEvent{}, false, fmt.Errorf("maintenance event %s has no NotBefore", ev.EventID)
+	}
+	notAfter, err := imds.ParseEventTime(ev.NotAfter)
+	if err != nil {
+		return maintenanceEvent{}, false, err
+	}
+	return maintenanceEvent{
+		ID:          ev.EventID,
+		Code:        ev.Code,
+		Description: ev.Description,
+		NotBefore:   notBefore,
+		NotAfter:    notAfter,
+	}, true, nil
+}
+
+// maintenanceWatcher はメンテナンスイベントをポーリングし、
+// 新しいイベントごとにDiscord通知、ゲーム内のお知らせ、フックスクリプトを予約する
+type maintenanceWatcher struct {
+	config   *Config
+	metadata *imds.Client
+	history  *historyLog
+	// 通知とフックの状況を保存する状態ファイル
+	state *handlerState
+	// イベントID → 予約済みのお知らせとフック
+	tracked map[string]*trackedMaintenance
+}
+
+type trackedMaintenance struct {
+	event  maintenanceEvent
+	timers []*time.Timer
+	// フックスクリプトを実行済みか。予約し直しても同じイベントでは二度実行しない
+	hookRan *atomic.Bool
+}
+
+func (t *trackedMaintenance) cancel() {
+	for _, timer := range t.timers {
+		timer.Stop()
+	}
+}
+
+// newMaintenanceWatcher は監視を作成する
+// 再起動前に通知・実行済みのイベントは state から読み込み、繰り返さない
+func newMaintenanceWatcher(config *Config, md *imds.Client, history *historyLog, state *handlerState) *maintenanceWatcher {
+	return &maintenanceWatcher{
+		config:   config,
+		metadata: md,
+		history:  history,
+		state:    state,
+		tracked:  make(map[string]*trackedMaintenance),
+	}
+}
+
+// poll はメンテナンスイベントを取得し、増えたイベントを予約し、なくなったイベントの予約を取り消す
+func (w *maintenanceWatcher) poll(ctx context.Context) error {
+	scheduled, err := w.metadata.ScheduledEvents(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get scheduled events: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, ev := range scheduled {
+		event, ok, err := parseMaintenanceEvent(ev)
+		if err != nil {
+			log.Printf("Ignoring maintenance event %s: %v", ev.EventID, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		seen[event.ID] = true
+
+		hookRan := new(atomic.Bool)
+		notify := true
+		if t, ok := w.tracked[event.ID]; ok {
+			if t.event.NotBefore.Equal(event.NotBefore) && t.event.NotAfter.Equal(event.NotAfter) {
+				continue
+			}
+			// 日時が変更された場合は予約し直す
+			log.Printf("Maintenance event %s was rescheduled to %s.", event.ID, event.NotBefore.Format(time.RFC3339))
+			t.cancel()
+			hookRan = t.hookRan
+		} else if saved := w.state.maintenance(event.ID); saved != nil {
+			// 再起動前に受け取ったイベント。お知らせは予約し直すが、履歴には記録済み
+			hookRan.Store(saved.HookRan)
+			notify = !saved.NotBefore.Equal(event.NotBefore) || !saved.NotAfter.Equal(event.NotAfter)
+			if notify {
+				log.Printf("Maintenance event %s was rescheduled to %s.", event.ID, event.NotBefore.Format(time.RFC3339))
+			} else {
+				log.Printf("Maintenance event %s was already notified before the restart. Rescheduling announcements.", event.ID)
+			}
+		} else {
+			log.Printf("Maintenance event received. Code: %s, NotBefore: %s, EventId: %s",
+				event.Code, event.NotBefore.Format(time.RFC3339), event.ID)
+			w.history.record(historyEntry{Event: historyMaintenance, Time: event.NotBefore, Detail: event.Code})
+		}
+		w.tracked[event.ID] = w.schedule(event, hookRan)
+		if notify {
+			w.notify(ctx, event)
+			w.state.updateMaintenance(event.ID, func(m *maintenanceState) {
+				m.NotBefore = event.NotBefore
+				m.NotAfter = event.NotAfter
+			})
+		}
+	}
+
+	for id, t := range w.tracked {
+		if !seen[id] {
+			log.Printf("Maintenance event %s is no longer scheduled. Cancelling announcements and hook.", id)
+			t.cancel()
+			delete(w.tracked, id)
+		}
+	}
+	w.state.pruneMaintenance(seen)
+	return nil
+}
+
+// stop は予約済みのお知らせとフックをすべて取り消す
+func (w *maintenanceWatcher) stop() {
+	for _, t := range w.tracked {
+		t.cancel()
+	}
+}
+
+// reload は設定を差し替え、予約済みのお知らせとフックを新しい設定で予約し直す
+// Discordには通知済みのため再通知しない
+func (w *maintenanceWatcher) reload(config *Config, md *imds.Client) {
+	w.config = config
+	w.metadata = md
+	for id, t := range w.tracked {
+		t.cancel()
+		w.tracked[id] = w.schedule(t.event, t.hookRan)
+	}
+}
+
+// schedule はゲーム内のお知らせとフックスクリプトを予約する
+// 予約したお知らせとフックは予約した時点の設定で実行する
+// hookRan はフックを実行済みかどうかで、実行済みの場合はフックを予約しない
+func (w *maintenanceWatcher) schedule(event maintenanceEvent, hookRan *atomic.Bool) *trackedMaintenance {
+	t := &trackedMaintenance{event: event, hookRan: hookRan}
+	now := time.Now()
+	config := w.config
+	state := w.state
+
+	for _, before := range config.Maintenance.announceBefore {
+		at := event.NotBefore.Add(-before)
+		if at.Before(now) {
+			continue
+		}
+		t.timers = append(t.timers, time.AfterFunc(at.Sub(now), func() {
+			announceMaintenance(config, event)
+		}))
+	}
+
+	if config.Maintenance.HookScript != "" && event.NotBefore.After(now) && !hookRan.Load() {
+		// 実行時刻を過ぎていても、NotBefore 前ならすぐに実行する
+		// 設定の再読み込み、日時の変更、再起動で予約し直しても、実行済みであれば繰り返さない
+		at := event.NotBefore.Add(-config.Maintenance.hookLeadTime)
+		t.timers = append(t.timers, time.AfterFunc(max(at.Sub(now), 0), func() {
+			if hookRan.CompareAndSwap(false, true) {
+				// 実行中に落ちても再起動後に繰り返さないよう、実行する前に保存する
+				state.updateMaintenance(event.ID, func(m *maintenanceState) { m.HookRan = true })
+				runMaintenanceHook(config, event)
+			}
+		}))
+	}
+	return t
+}
+
+// announceMaintenance はゲーム内でメンテナンスの予定をお知らせする
+func announceMaintenance(config *Config, event maintenanceEvent) {
+	server := newMinecraftServer(config)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), config.stepTimeout("warn"))
+	defer cancel()
+
+	message := fmt.Sprintf("§e[お知らせ] %s にサーバーのメンテナンスが予定されています。この間サーバーが停止する可能性があります。",
+		event.window(time.Local))
+	if config.Countdown.Language == "en" {
+		message = fmt.Sprintf("§e[Notice] Server maintenance is scheduled for %s. The server may stop during this window.",
+			event.window(time.Local))
+	}
+	if _, err := server.Execute(ctx, "say "+message); err != nil {
+		log.Printf("Failed to announce maintenance event %s: %v", event.ID, err)
+		return
+	}
+	log.Printf("Announced maintenance event %s in game.", event.ID)
+}
+
+// runMaintenanceHook はメンテナンス前のフックスクリプトを実行する
+func runMaintenanceHook(config *Config, event maintenanceEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.stepTimeout("script"))
+	defer cancel()
+
+	log.Printf("Running maintenance hook for event %s (NotBefore: %s).", event.ID, event.NotBefore.Format(time.RFC3339))
+	if err := runCommand(ctx, "/bin/sh", []string{config.Maintenance.HookScript}, event.Env()); err != nil {
+		log.Printf("Maintenance hook for event %s failed: %v", event.ID, err)
+	}
+}
+
+// notify はメンテナンスの予定をDiscordに通知する
+func (w *maintenanceWatcher) notify(ctx context.Context, event maintenanceEvent) {
+	if w.config.DiscordWebhookURL == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, w.config.stepTimeout("notify"))
+	defer cancel()
+
+	fields := []discordField{
+		{Name: "Event", Value: event.Code, Inline: true},
+		{Name: "Window", Value: event.window(time.UTC), Inline: true},
+		{Name: "Instance", Value: instanceIDOrNA(ctx, w.metadata), Inline: true},
+		{Name: "Event ID", Value: event.ID, Inline: false},
+	}
+	if event.Description != "" {
+		fields = append(fields, discordField{Name: "Description", Value: event.Description, Inline: false})
+	}
+	err := sendDiscordNotification(ctx, w.config.DiscordWebhookURL, "EC2 Scheduled Maintenance",
+		"🛠️ **Scheduled maintenance event received.**\nThe server may stop during the maintenance window.",
+		colorInfo, fields)
+	if err != nil {
+		log.Printf("Failed to send maintenance notification: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"common/imds"
+)
+
+// fakeMaintenanceIMDS は予定メンテナンスを1件返すIMDSと、通知を数えるDiscordのWebhook
+type fakeMaintenanceIMDS struct {
+	imds    *httptest.Server
+	discord *httptest.Server
+
+	mu            sync.Mutex
+	notifications int
+}
+
+func newFakeMaintenanceIMDS(t *testing.T, event imds.ScheduledEvent) *fakeMaintenanceIMDS {
+	t.Helper()
+	f := &fakeMaintenanceIMDS{}
+	f.imds = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/api/token":
+			fmt.Fprint(w, "token")
+		case "/latest/meta-data/instance-id":
+			fmt.Fprint(w, "i-test")
+		case "/latest/meta-data/events/maintenance/scheduled":
+			json.NewEncoder(w).Encode([]imds.ScheduledEvent{event})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(f.imds.Close)
+	f.discord = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.notifications++
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(f.discord.Close)
+	return f
+}
+
+// startMaintenanceWatcher は新しく起動したハンドラーと同じように、状態ファイルを読み込んでメンテナンスイベントを取得する
+func startMaintenanceWatcher(t *testing.T, config *Config, statePath string) *maintenanceWatcher {
+	t.Helper()
+	state, err := loadState(statePath)
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	md := imds.New(imds.WithBaseURL(config.IMDS.BaseURL))
+	history := &historyLog{config: config, instance: &awsProvider{metadata: md}}
+	w := newMaintenanceWatcher(config, md, history, state)
+	t.Cleanup(w.stop)
+	if err := w.poll(t.Context()); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	return w
+}
+
+func readLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestMaintenanceWatcherSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	hookOutput := filepath.Join(dir, "hook.log")
+	hookScript := filepath.Join(dir, "hook.sh")
+	if err := os.WriteFile(hookScript, []byte("echo \"$MAINTENANCE_EVENT_ID\" >> "+hookOutput+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// フックの実行時刻を過ぎているため、受け取るとすぐにフックを実行する
+	event := imds.ScheduledEvent{
+		Code:      "system-reboot",
+		EventID:   "instance-event-0123456789abcdef0",
+		NotBefore: time.Now().Add(5 * time.Minute).UTC().Format("2 Jan 2006 15:04:05 GMT"),
+		State:     "active",
+	}
+	fake := newFakeMaintenanceIMDS(t, event)
+
+	config := &Config{
+		DiscordWebhookURL: fake.discord.URL,
+		IMDS:              IMDSConfig{BaseURL: fake.imds.URL},
+		History:           HistoryConfig{File: filepath.Join(dir, "history.jsonl")},
+		Maintenance:       MaintenanceConfig{Enabled: true, AnnounceBefore: []string{}, HookScript: hookScript},
+	}
+	if err := config.Maintenance.parse(); err != nil {
+		t.Fatal(err)
+	}
+	statePath := filepath.Join(dir, "state.json")
+
+	first := startMaintenanceWatcher(t, config, statePath)
+	deadline := time.Now().Add(5 * time.Second)
+	for readLines(t, hookOutput) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("maintenance hook did not run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	first.stop()
+
+	// 再起動しても通知、履歴の記録、フックを繰り返さない
+	second := startMaintenanceWatcher(t, config, statePath)
+	if tracked := second.tracked[event.EventID]; tracked == nil || len(tracked.timers) != 0 || !tracked.hookRan.Load() {
+		t.Errorf("tracked = %+v, want the event with the hook already run", tracked)
+	}
+	fake.mu.Lock()
+	notifications := fake.notifications
+	fake.mu.Unlock()
+	if notifications != 1 {
+		t.Errorf("Discord notifications = %d, want 1", notifications)
+	}
+	if n := readLines(t, config.History.File); n != 1 {
+		t.Errorf("history entries = %d, want 1", n)
+	}
+	if n := readLines(t, hookOutput); n != 1 {
+		t.Errorf("hook runs = %d, want 1", n)
+	}
+}
+
+func TestMaintenanceStatePrunesFinishedEvents(t *testing.T) {
+	state := &handlerState{path: filepath.Join(t.TempDir(), "state.json")}
+	state.updateMaintenance("done", func(m *maintenanceState) { m.HookRan = true })
+	state.updateMaintenance("scheduled", func(m *maintenanceState) {})
+
+	// 完了・キャンセルされたイベントは次に予定されても別のIDになるため、記録を残さない
+	state.pruneMaintenance(map[string]bool{"scheduled": true})
+	saved, err := loadState(state.path)
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if saved.maintenance("done") != nil || saved.maintenance("scheduled") == nil {
+		t.Errorf("saved maintenance = %v, want only the scheduled event", saved.Maintenance)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"common/rcon"
+)
+
+// minecraftServer はsystemdで管理されているMinecraftサーバーを操作する
+type minecraftServer struct {
+	service      string
+	rconAddr     string
+	passwordFile string
+
+	// パイプラインの間はRCON接続を使い回す
+	// 並列グループのフックから同時に使われるため、作成と破棄は mu で守る (rcon.Client 自体は並行に使っても安全)
+	mu   sync.Mutex
+	rcon *rcon.Client
+}
+
+func newMinecraftServer(config *Config) *minecraftServer {
+	return &minecraftServer{
+		service:      config.MinecraftService,
+		rconAddr:     fmt.Sprintf("%s:%d", config.RCON.Host, config.RCON.Port),
+		passwordFile: config.RCON.PasswordFile,
+	}
+}
+
+// Execute はRCONでコマンドを実行する (countdown.Commander を満たす)
+func (s *minecraftServer) Execute(ctx context.Context, command string) (string, error) {
+	client, err := s.client()
+	if err != nil {
+		return "", err
+	}
+	return client.Execute(ctx, command)
+}
+
+// client はRCONクライアントを返す。最初に呼ばれたときに作成する
+// パスワードファイルを読めなかった場合は作成せず、次の呼び出しで読み直す
+func (s *minecraftServer) client() (*rcon.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rcon == nil {
+		client, err := rcon.NewClientFromFile(s.rconAddr, s.passwordFile)
+		if err != nil {
+			return nil, err
+		}
+		s.rcon = client
+	}
+	return s.rcon, nil
+}
+
+// Close はRCON接続を閉じる
+func (s *minecraftServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rcon == nil {
+		return nil
+	}
+	return s.rcon.Close()
+}
+
+// mainPID はサービスのメインプロセス (Java) のPIDを返す。起動していない場合は0
+func (s *minecraftServer) mainPID(ctx context.Context) (int, error) {
+	out, err := exec.CommandContext(ctx, "systemctl", "show", "--property=MainPID", "--value", s.service).Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get MainPID of %s: %w", s.service, err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse MainPID of %s: %w", s.service, err)
+	}
+	return pid, nil
+}
+
+// stopService はsystemctl stopでサービスを停止する (RCONが使えない場合のフォールバック)
+func (s *minecraftServer) stopService(ctx context.Context) error {
+	args := []string{"systemctl", "stop", s.service}
+	if os.Geteuid() != 0 {
+		// ハンドラーは一般ユーザーで動くため sudo を使う (パスワードなしで許可しておくこと)
+		args = append([]string{"sudo", "-n"}, args...)
+	}
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl stop %s failed: %w (output: %s)", s.service, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// waitForExit はプロセスが終了するまで待つ
+func waitForExit(ctx context.Context, pid int) error {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		// シグナル0はプロセスの存在確認にだけ使える
+		if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("process %d did not exit: %w", pid, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// runCommand は外部コマンドを実行し、出力を1行ずつログに流す
+// env は現在の環境変数に追加して渡される
+func runCommand(ctx context.Context, name string, args []string, env []string) error {
+	return runLabeledCommand(ctx, filepath.Base(name), name, args, env)
+}
+
+// runLabeledCommand は出力の各行に label を付けてログに出す runCommand
+func runLabeledCommand(ctx context.Context, label, name string, args []string, env []string) error {
+	log.Printf("Executing command: %s %s", name, strings.Join(args, " "))
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	// タイムアウトや取り消しのときはスクリプトが起動した子プロセスもまとめて止める
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// 強制終了したあと、子プロセスが出力を開いたままでも待ち続けないようにする
+	cmd.WaitDelay = 5 * time.Second
+
+	// 標準出力と標準エラーを同じWriterに渡すと、書き込まれた順にログに出る
+	out := &lineLogger{prefix: label}
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	err := cmd.Run()
+	out.Flush()
+	if err != nil {
+		if tail := out.Tail(); tail != "" {
+			return fmt.Errorf("command %s failed: %w. Last output: %s", name, err, tail)
+		}
+		return fmt.Errorf("command %s failed: %w", name, err)
+	}
+	log.Printf("Command %s executed successfully.", name)
+	return nil
+}
+
+// lineLogger はコマンドの出力を行ごとにログに出す io.Writer
+// エラーメッセージに含めるため、最後の数行を覚えておく
+type lineLogger struct {
+	prefix string
+	buf    []byte
+	tail   []string
+}
+
+// エラーメッセージに含める出力の行数
+const outputTailLines = 5
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.logLine(string(l.buf[:i]))
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush は改行で終わっていない最後の出力をログに出す
+func (l *lineLogger) Flush() {
+	if len(l.buf) > 0 {
+		l.logLine(string(l.buf))
+		l.buf = nil
+	}
+}
+
+// Tail は最後の数行を返す
+func (l *lineLogger) Tail() string {
+	return strings.Join(l.tail, " / ")
+}
+
+func (l *lineLogger) logLine(line string) {
+	line = strings.TrimRight(line, "\r")
+	log.Printf("[%s] %s", l.prefix, line)
+	l.tail = append(l.tail, line)
+	if len(l.tail) > outputTailLines {
+		l.tail = l.tail[1:]
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRCON はコマンドを記録して空の応答を返すRCONサーバー
+type fakeRCON struct {
+	listener net.Listener
+
+	mu          sync.Mutex
+	connections int
+	commands    []string
+}
+
+func newFakeRCON(t *testing.T) *fakeRCON {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRCON{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeRCON) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.connections++
+		f.mu.Unlock()
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRCON) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var header struct{ Length, ID, Type int32 }
+		if err := binary.Read(conn, binary.LittleEndian, &header); err != nil {
+			return
+		}
+		body := make([]byte, header.Length-8)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		command := string(bytes.TrimRight(body, "\x00"))
+		// 認証 (3) には認証応答 (2)、コマンドと終端の空パケットには RESPONSE_VALUE (0) を同じIDで返す
+		respType := int32(0)
+		switch {
+		case header.Type == 3:
+			respType = 2
+		case header.Type == 2:
+			f.mu.Lock()
+			f.commands = append(f.commands, command)
+			f.mu.Unlock()
+		}
+		var resp bytes.Buffer
+		binary.Write(&resp, binary.LittleEndian, []int32{10, header.ID, respType})
+		resp.Write([]byte{0, 0})
+		if _, err := conn.Write(resp.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+func TestParallelRCONHooksShareOneConnection(t *testing.T) {
+	server := newFakeRCON(t)
+	passwordFile := filepath.Join(t.TempDir(), "rcon.pass")
+	if err := os.WriteFile(passwordFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	addr := server.listener.Addr().(*net.TCPAddr)
+	config := &Config{RCON: RCONConfig{Host: "127.0.0.1", Port: addr.Port, PasswordFile: passwordFile}}
+
+	hooks := []HookConfig{{Parallel: []HookConfig{{Action: "save"}, {Action: "stop"}}}}
+	if err := parseHooks(hooks, false); err != nil {
+		t.Fatalf("parseHooks: %v", err)
+	}
+	run := &shutdownRun{
+		config: config,
+		server: newMinecraftServer(config),
+		action: &InstanceAction{Action: ActionTerminate, Time: time.Now().Add(time.Minute)},
+		// サーバーが動いていることにする
+		pid: os.Getpid(),
+	}
+	p := &Pipeline{Name: "test"}
+	p.Steps = run.hookSteps(hooks, p, time.Now())
+	p.Run(t.Context())
+	if err := run.server.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+
+	want := map[string]string{"save": "ok", "stop": "ok"}
+	if got := statuses(p); !maps.Equal(got, want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if got := slices.Sorted(slices.Values(server.commands)); !slices.Equal(got, []string{"save-all flush", "stop"}) {
+		t.Errorf("commands = %v, want save-all flush and stop", got)
+	}
+	// 同時に使い始めても接続は1つだけ作られる
+	if server.connections != 1 {
+		t.Errorf("connections = %d, want 1", server.connections)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// errStepSkipped はステップを実行する必要がなかったことを示す
+var errStepSkipped = errors.New("step skipped")
+
+// Step はパイプラインの1ステップ
+type Step struct {
+	Name    string
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+	// 失敗したら後続のステップを実行しない (デフォルトは失敗しても続ける)
+	StopOnFailure bool
+	// 前のステップの失敗で中止された場合も実行する (通知など)
+	Always bool
+	// パイプライン全体の期限を過ぎても Timeout の間は実行する (最後に送る中断イベントなど)
+	IgnoreDeadline bool
+	// 並列に実行するステップ。指定した場合は Name, Timeout, Run を使わない
+	Parallel []Step
+}
+
+// StepResult はステップの実行結果
+type StepResult struct {
+	Name     string
+	Status   string // ok, failed, skipped, aborted
+	Duration time.Duration
+	Err      error
+}
+
+// Pipeline は順番に実行するステップの集まり
+type Pipeline struct {
+	Name  string
+	Steps []Step
+	// 実行済みステップの結果。後続のステップ (通知など) から参照できる
+	Results []StepResult
+	// 再起動前に完了していたステップ。同じ処理を繰り返さないよう実行を省略する
+	Completed map[string]bool
+	// OnStepFinished は各ステップの終了後に呼ばれる (nil可)。状態ファイルへの記録に使う
+	// 並列グループのステップでは複数のgoroutineから呼ばれるが、同時に呼ばれることはない
+	OnStepFinished func(result StepResult)
+
+	mu sync.Mutex
+}
+
+// Run はステップを順番に実行する
+// 中断までの時間は限られているため、ステップが失敗しても残りのステップは続けて実行する
+// ただし StopOnFailure のステップが失敗した場合は、Always のステップ以外を中止する
+// 各ステップの結果は構造化ログとして出力する
+func (p *Pipeline) Run(ctx context.Context) {
+	logger := slog.With("pipeline", p.Name)
+	logger.Info("pipeline started", "steps", len(p.Steps))
+	start := time.Now()
+
+	abortedBy := ""
+	for _, step := range p.Steps {
+		group := step.Parallel
+		if len(group) == 0 {
+			group = []Step{step}
+		}
+
+		if abortedBy != "" && !step.Always {
+			for _, s := range group {
+				logger.Warn("step finished", "step", s.Name, "status", "aborted", "reason", abortedBy+" failed")
+				p.record(StepResult{Name: s.Name, Status: "aborted"})
+			}
+			continue
+		}
+
+		var wg sync.WaitGroup
+		failed := make([]bool, len(group))
+		for i, s := range group {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				failed[i] = !p.runStep(ctx, logger, s)
+			}()
+		}
+		wg.Wait()
+
+		for i, s := range group {
+			if failed[i] && (s.StopOnFailure || step.StopOnFailure) && abortedBy == "" {
+				abortedBy = s.Name
+				logger.Error("pipeline aborted", "step", s.Name)
+			}
+		}
+	}
+
+	logger.Info("pipeline finished", "duration", time.Since(start).Round(time.Millisecond), "failed", p.Failed())
+}
+
+// runStep はステップを1つ実行して結果を記録する。失敗した場合はfalseを返す
+func (p *Pipeline) runStep(ctx context.Context, logger *slog.Logger, step Step) bool {
+	if p.Completed[step.Name] {
+		logger.Info("step finished", "step", step.Name, "status", "skipped", "reason", "already completed before restart")
+		p.record(StepResult{Name: step.Name, Status: "skipped"})
+		return true
+	}
+
+	parent := ctx
+	if step.IgnoreDeadline {
+		parent = context.WithoutCancel(ctx)
+	}
+	stepCtx, cancel := context.WithTimeout(parent, step.Timeout)
+	stepStart := time.Now()
+	err := step.Run(stepCtx)
+	cancel()
+
+	result := StepResult{Name: step.Name, Duration: time.Since(stepStart), Err: err}
+	switch {
+	case err == nil:
+		result.Status = "ok"
+		logger.Info("step finished", "step", step.Name, "status", result.Status, "duration", result.Duration.Round(time.Millisecond))
+	case errors.Is(err, errStepSkipped):
+		result.Status = "skipped"
+		result.Err = nil
+		logger.Info("step finished", "step", step.Name, "status", result.Status, "reason", err.Error())
+	default:
+		result.Status = "failed"
+		logger.Error("step finished", "step", step.Name, "status", result.Status, "duration", result.Duration.Round(time.Millisecond), "error", err)
+	}
+	p.record(result)
+	return result.Status != "failed"
+}
+
+func (p *Pipeline) record(result StepResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Results = append(p.Results, result)
+	stats.observeStep(p.Name, result)
+	if p.OnStepFinished != nil {
+		p.OnStepFinished(result)
+	}
+}
+
+// Failed は失敗したステップの数を返す
+func (p *Pipeline) Failed() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, r := range p.Results {
+		if r.Status == "failed" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// statuses はステップ名 → 結果のステータスを返す
+func statuses(p *Pipeline) map[string]string {
+	m := make(map[string]string)
+	for _, r := range p.Results {
+		m[r.Name] = r.Status
+	}
+	return m
+}
+
+func stepOK(ctx context.Context) error { return nil }
+
+func stepFail(ctx context.Context) error { return errors.New("boom") }
+
+func TestPipelineRunsParallelGroupConcurrently(t *testing.T) {
+	// 両方のステップが同時に実行されていなければ、どちらも started を待ったままタイムアウトする
+	var started sync.WaitGroup
+	started.Add(2)
+	waitForBoth := func(ctx context.Context) error {
+		started.Done()
+		done := make(chan struct{})
+		go func() {
+			started.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	var mu sync.Mutex
+	var order []string
+	step := func(name string, run func(context.Context) error) Step {
+		return Step{Name: name, Timeout: time.Second, Run: func(ctx context.Context) error {
+			err := run(ctx)
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return err
+		}}
+	}
+
+	p := &Pipeline{Name: "test", Steps: []Step{
+		step("first", stepOK),
+		{Parallel: []Step{step("backup", waitForBoth), step("script", waitForBoth)}},
+		step("last", stepOK),
+	}}
+	p.Run(t.Context())
+
+	want := map[string]string{"first": "ok", "backup": "ok", "script": "ok", "last": "ok"}
+	if got := statuses(p); !maps.Equal(got, want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+	// 並列グループが終わるまで次のステップは始まらない
+	if order[0] != "first" || order[3] != "last" {
+		t.Errorf("order = %v, want the parallel group between first and last", order)
+	}
+}
+
+func TestPipelineStopOnFailure(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []Step
+		want  map[string]string
+	}{
+		{
+			name: "continue on failure by default",
+			steps: []Step{
+				{Name: "save", Timeout: time.Second, Run: stepFail},
+				{Name: "stop", Timeout: time.Second, Run: stepOK},
+			},
+			want: map[string]string{"save": "failed", "stop": "ok"},
+		},
+		{
+			name: "abort all but always",
+			steps: []Step{
+				{Name: "save", Timeout: time.Second, Run: stepFail, StopOnFailure: true},
+				{Name: "stop", Timeout: time.Second, Run: stepOK},
+				{Parallel: []Step{
+					{Name: "backup", Timeout: time.Second, Run: stepOK},
+					{Name: "script", Timeout: time.Second, Run: stepOK},
+				}},
+				{Name: "notify", Timeout: time.Second, Run: stepOK, Always: true},
+			},
+			want: map[string]string{"save": "failed", "stop": "aborted", "backup": "aborted", "script": "aborted", "notify": "ok"},
+		},
+		{
+			name: "failure in a parallel group",
+			steps: []Step{
+				{Parallel: []Step{
+					{Name: "backup", Timeout: time.Second, Run: stepFail, StopOnFailure: true},
+					{Name: "script", Timeout: time.Second, Run: stepOK},
+				}},
+				{Name: "stop", Timeout: time.Second, Run: stepOK},
+				{Name: "notify", Timeout: time.Second, Run: stepOK, Always: true},
+			},
+			// グループ内の他のステップは最後まで実行される
+			want: map[string]string{"backup": "failed", "script": "ok", "stop": "aborted", "notify": "ok"},
+		},
+		{
+			name: "stop on failure of the whole group",
+			steps: []Step{
+				{StopOnFailure: true, Parallel: []Step{
+					{Name: "backup", Timeout: time.Second, Run: stepOK},
+					{Name: "script", Timeout: time.Second, Run: stepFail},
+				}},
+				{Name: "stop", Timeout: time.Second, Run: stepOK},
+			},
+			want: map[string]string{"backup": "ok", "script": "failed", "stop": "aborted"},
+		},
+		{
+			name: "skipped is not a failure",
+			steps: []Step{
+				{Name: "backup", Timeout: time.Second, StopOnFailure: true, Run: func(ctx context.Context) error {
+					return fmt.Errorf("%w: no backup command configured", errStepSkipped)
+				}},
+				{Name: "stop", Timeout: time.Second, Run: stepOK},
+			},
+			want: map[string]string{"backup": "skipped", "stop": "ok"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Pipeline{Name: "test", Steps: tt.steps}
+			p.Run(t.Context())
+			if got := statuses(p); !maps.Equal(got, tt.want) {
+				t.Errorf("statuses = %v, want %v", got, tt.want)
+			}
+			failed := 0
+			for _, status := range tt.want {
+				if status == "failed" {
+					failed++
+				}
+			}
+			if p.Failed() != failed {
+				t.Errorf("Failed() = %d, want %d", p.Failed(), failed)
+			}
+		})
+	}
+}
+
+func TestPipelineStepTimeout(t *testing.T) {
+	p := &Pipeline{Name: "test", Steps: []Step{
+		{Name: "wait", Timeout: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+	}}
+	p.Run(t.Context())
+	if len(p.Results) != 1 || !errors.Is(p.Results[0].Err, context.DeadlineExceeded) {
+		t.Errorf("results = %v, want the step to time out", p.Results)
+	}
+}
+
+func TestPipelineSkipsCompletedSteps(t *testing.T) {
+	var ran []string
+	var mu sync.Mutex
+	run := func(name string) func(context.Context) error {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			ran = append(ran, name)
+			mu.Unlock()
+			return nil
+		}
+	}
+	var finished []string
+	p := &Pipeline{
+		Name: "test",
+		Steps: []Step{
+			{Name: "save", Timeout: time.Second, Run: run("save")},
+			{Name: "stop", Timeout: time.Second, Run: run("stop")},
+		},
+		Completed:      map[string]bool{"save": true},
+		OnStepFinished: func(r StepResult) { finished = append(finished, r.Name+":"+r.Status) },
+	}
+	p.Run(t.Context())
+
+	if !slices.Equal(ran, []string{"stop"}) {
+		t.Errorf("ran = %v, want only stop", ran)
+	}
+	if want := []string{"save:skipped", "stop:ok"}; !slices.Equal(finished, want) {
+		t.Errorf("OnStepFinished = %v, want %v", finished, want)
+	}
+}
+
+func TestPipelineIgnoreDeadline(t *testing.T) {
+	// 前のステップでパイプライン全体の期限を使い切った状態
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	waitForDeadline := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	p := &Pipeline{Name: "test", Steps: []Step{
+		{Name: "backup", Timeout: time.Second, Run: waitForDeadline},
+		{Name: "notify", Timeout: time.Second, Run: func(ctx context.Context) error { return ctx.Err() }},
+		{Name: "publish", Timeout: time.Second, IgnoreDeadline: true, Run: func(ctx context.Context) error {
+			if _, ok := ctx.Deadline(); !ok {
+				return errors.New("step has no timeout of its own")
+			}
+			return ctx.Err()
+		}},
+	}}
+	p.Run(ctx)
+
+	want := map[string]string{"backup": "failed", "notify": "failed", "publish": "ok"}
+	if got := statuses(p); !maps.Equal(got, want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+}
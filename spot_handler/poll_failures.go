@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"common/imds"
+)
+
+const (
+	defaultPollMaxInterval = time.Minute
+	defaultPollAlertAfter  = 5
+)
+
+// PollFailureConfig は中断通知の取得に失敗し続けた場合の設定
+type PollFailureConfig struct {
+	// ネットワークエラーや5xxのときに間隔を延ばす上限
+	MaxInterval string `yaml:"maxInterval"`
+	// 連続で何回失敗したら通知するか (0 の場合はデフォルト値、負の場合は通知しない)
+	AlertAfter int `yaml:"alertAfter"`
+	// 通知先のDiscordのWebhook URL。空の場合は discordWebhookUrl を使う
+	WebhookURL string `yaml:"webhookUrl"`
+
+	maxInterval time.Duration
+}
+
+// parse は文字列で書かれた時間をパースし、未設定の項目にデフォルト値を入れる
+func (c *PollFailureConfig) parse() error {
+	c.maxInterval = defaultPollMaxInterval
+	if c.MaxInterval != "" {
+		var err error
+		if c.maxInterval, err = time.ParseDuration(c.MaxInterval); err != nil {
+			return fmt.Errorf("invalid pollFailures maxInterval: %w", err)
+		}
+	}
+	if c.AlertAfter == 0 {
+		c.AlertAfter = defaultPollAlertAfter
+	}
+	return nil
+}
+
+// transientError はネットワークエラーや5xxなど、間隔を空ければ回復する可能性があるエラーかを返す
+// 4xx (トークンの不備など) は待っても変わらないため、間隔を延ばさない
+func transientError(err error) bool {
+	var imdsErr *imds.StatusError
+	if errors.As(err, &imdsErr) {
+		return imdsErr.StatusCode >= 500
+	}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return true
+}
+
+// pollFailures は中断通知の取得の連続失敗を数え、次のポーリングまでの間隔を決める
+// 失敗が続いた場合は、中断を検知できない状態であることを通知し、回復したら回復を通知する
+// (IMDSのホップ数の設定ミスなどに何週間も気づかないことがないように)
+type pollFailures struct {
+	config *Config
+
+	count   int
+	since   time.Time // 最初に失敗した時刻
+	lastErr error
+	alerted bool
+}
+
+// observe はポーリングの結果を記録し、次のポーリングまでの間隔を返す
+// 成功した場合 (中断通知がない404も含む) は通常の間隔に戻す
+// ネットワークエラーや5xxの場合は失敗の回数に応じて間隔を延ばし、ジッターを加える
+func (f *pollFailures) observe(ctx context.Context, err error) time.Duration {
+	if err == nil {
+		if f.alerted {
+			f.notifyRecovered(ctx)
+		}
+		f.count = 0
+		f.alerted = false
+		return f.config.pollingInterval
+	}
+
+	if f.count == 0 {
+		f.since = time.Now()
+	}
+	f.count++
+	f.lastErr = err
+	if !f.alerted && f.config.PollFailures.AlertAfter > 0 && f.count >= f.config.PollFailures.AlertAfter {
+		f.notifyBlind(ctx)
+		f.alerted = true
+	}
+
+	if !transientError(err) {
+		return f.config.pollingInterval
+	}
+	return backoff(f.config.pollingInterval, f.config.PollFailures.maxInterval, f.count)
+}
+
+// backoff は失敗の回数に応じて2倍ずつ延ばした間隔 (上限 max) を返す
+// 複数のインスタンスが同時に問い合わせ直さないよう、半分から全体の範囲でランダムにずらす
+func backoff(base, max time.Duration, failures int) time.Duration {
+	d := base
+	for i := 0; i < failures && d < max; i++ {
+		d *= 2
+	}
+	d = min(d, max)
+	if d < base {
+		// 上限が通常の間隔より短い場合
+		return base
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+func (f *pollFailures) webhookURL() string {
+	if f.config.PollFailures.WebhookURL != "" {
+		return f.config.PollFailures.WebhookURL
+	}
+	return f.config.DiscordWebhookURL
+}
+
+// notifyBlind は中断通知を取得できず、中断を検知できない状態であることを通知する
+func (f *pollFailures) notifyBlind(ctx context.Context) {
+	log.Printf("Interruption polling has failed %d times in a row since %s. The handler cannot detect interruptions.",
+		f.count, f.since.Format(time.RFC3339))
+	f.send(ctx, "Spot Handler Cannot Poll Metadata",
+		"🙈 **The handler cannot detect interruptions.**\nPolling the metadata service keeps failing. The server will not be stopped safely if it is interrupted.",
+		colorWarning, []discordField{
+			{Name: "Consecutive Failures", Value: fmt.Sprint(f.count), Inline: true},
+			{Name: "Failing Since (UTC)", Value: f.since.UTC().Format(time.RFC3339), Inline: true},
+			{Name: "Last Error", Value: f.lastErr.Error(), Inline: false},
+		})
+}
+
+// notifyRecovered はポーリングが回復したことを通知する
+func (f *pollFailures) notifyRecovered(ctx context.Context) {
+	blind := time.Since(f.since).Round(time.Second)
+	log.Printf("Interruption polling recovered after %d failures (%s).", f.count, blind)
+	f.send(ctx, "Spot Handler Polling Recovered",
+		"✅ **The handler can detect interruptions again.**",
+		colorSuccess, []discordField{
+			{Name: "Failures", Value: fmt.Sprint(f.count), Inline: true},
+			{Name: "Blind For", Value: blind.String(), Inline: true},
+		})
+}
+
+func (f *pollFailures) send(ctx context.Context, title, message string, color int, fields []discordField) {
+	webhookURL := f.webhookURL()
+	if webhookURL == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, f.config.stepTimeout("notify"))
+	defer cancel()
+	if err := sendDiscordNotification(ctx, webhookURL, title, message, color, fields); err != nil {
+		log.Printf("Failed to send polling alert: %v", err)
+	}
+}
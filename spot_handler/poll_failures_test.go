@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"common/imds"
+)
+
+func TestTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"network error", errors.New("connection refused"), true},
+		{"imds 5xx", &imds.StatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"imds 4xx", &imds.StatusError{StatusCode: http.StatusUnauthorized}, false},
+		{"wrapped imds 4xx", fmt.Errorf("failed to get instance-action: %w", &imds.StatusError{StatusCode: http.StatusForbidden}), false},
+		{"metadata server 5xx", &statusError{StatusCode: http.StatusInternalServerError}, true},
+		{"metadata server 4xx", fmt.Errorf("watch: %w", &statusError{StatusCode: http.StatusBadRequest}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := transientError(tt.err); got != tt.want {
+				t.Errorf("transientError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, max := 5*time.Second, time.Minute
+	tests := []struct {
+		failures int
+		// ジッターを加える前の間隔。結果はこの半分から全体の範囲になる
+		want time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, time.Minute},
+		{20, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.failures), func(t *testing.T) {
+			for range 100 {
+				got := backoff(base, max, tt.failures)
+				if got < tt.want/2 || got > tt.want {
+					t.Fatalf("backoff(%d) = %s, want between %s and %s", tt.failures, got, tt.want/2, tt.want)
+				}
+			}
+		})
+	}
+
+	// 上限が通常の間隔より短い場合は通常の間隔のまま
+	if got := backoff(time.Minute, 30*time.Second, 3); got != time.Minute {
+		t.Errorf("backoff with max below base = %s, want %s", got, time.Minute)
+	}
+}
+
+func TestPollFailuresObserve(t *testing.T) {
+	config := &Config{
+		pollingInterval: 5 * time.Second,
+		// 通知は送らない
+		PollFailures: PollFailureConfig{AlertAfter: -1, maxInterval: time.Minute},
+	}
+	f := &pollFailures{config: config}
+	ctx := t.Context()
+
+	// 4xx は待っても変わらないため通常の間隔
+	if got := f.observe(ctx, &imds.StatusError{StatusCode: http.StatusUnauthorized}); got != config.pollingInterval {
+		t.Errorf("interval after 4xx = %s, want %s", got, config.pollingInterval)
+	}
+	// 失敗が続くと間隔が延びる (2回目の失敗は 20s の半分から全体)
+	if got := f.observe(ctx, errors.New("timeout")); got < 10*time.Second || got > 20*time.Second {
+		t.Errorf("interval after 2 failures = %s, want between 10s and 20s", got)
+	}
+	if f.count != 2 {
+		t.Errorf("count = %d, want 2", f.count)
+	}
+	// 成功したら元の間隔に戻る
+	if got := f.observe(ctx, nil); got != config.pollingInterval {
+		t.Errorf("interval after success = %s, want %s", got, config.pollingInterval)
+	}
+	if f.count != 0 {
+		t.Errorf("count after success = %d, want 0", f.count)
+	}
+}
+
+func TestPollFailuresAlertsOnce(t *testing.T) {
+	config := &Config{
+		pollingInterval: time.Second,
+		// Webhook がないため通知は送られないが、通知済みかどうかは記録される
+		PollFailures: PollFailureConfig{AlertAfter: 3, maxInterval: time.Minute},
+	}
+	f := &pollFailures{config: config}
+	ctx := t.Context()
+
+	for i := 1; i <= 5; i++ {
+		f.observe(ctx, errors.New("timeout"))
+		if want := i >= 3; f.alerted != want {
+			t.Errorf("after %d failures alerted = %v, want %v", i, f.alerted, want)
+		}
+	}
+	f.observe(ctx, nil)
+	if f.alerted {
+		t.Error("alerted is still set after recovery")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"common/imds"
+)
+
+// 中断通知を取得するクラウド (config.yaml の provider)
+const (
+	providerAWS   = "aws"
+	providerGCP   = "gcp"
+	providerAzure = "azure"
+)
+
+// instanceIdentifier は通知に載せるインスタンスIDを返すもの
+type instanceIdentifier interface {
+	InstanceID(ctx context.Context) (string, error)
+}
+
+// instanceDescriber はインスタンスの配置を返すもの (EC2のみ)
+// 中断イベントに載せ、置き換えのインスタンスを選ぶのに使う
+type instanceDescriber interface {
+	AvailabilityZone(ctx context.Context) (string, error)
+	InstanceType(ctx context.Context) (string, error)
+}
+
+// interruptionProvider はクラウドごとの中断通知の取得方法
+type interruptionProvider interface {
+	instanceIdentifier
+	// Name はログに出すプロバイダー名を返す
+	Name() string
+	// Check は中断通知があれば内容を返す。なければnilを返す
+	Check(ctx context.Context) (*InstanceAction, error)
+	// Acknowledge はシャットダウン処理が終わったことをクラウドに伝える (不要なクラウドでは何もしない)
+	Acknowledge(ctx context.Context, action *InstanceAction) error
+}
+
+// statusError はGCPやAzureのメタデータサーバーが予期しないHTTPステータスを返したことを示す
+type statusError struct {
+	Path       string
+	StatusCode int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d for %s", e.StatusCode, e.Path)
+}
+
+// closer はバックグラウンドで監視しているため、使い終わったら止める必要があるプロバイダー
+type closer interface {
+	close()
+}
+
+// closeProvider はプロバイダーのバックグラウンドの監視を止める
+func closeProvider(p interruptionProvider) {
+	if c, ok := p.(closer); ok {
+		c.close()
+	}
+}
+
+// newInterruptionProvider は設定に従ってプロバイダーを作成する
+// AWSの場合は md (リバランス推奨やメンテナンスイベントと共用するIMDSクライアント) を使う
+func newInterruptionProvider(config *Config, md *imds.Client) (interruptionProvider, error) {
+	switch config.Provider {
+	case providerAWS:
+		return &awsProvider{metadata: md}, nil
+	case providerGCP:
+		p := newGCPProvider(config.GCP.BaseURL)
+		p.start()
+		return p, nil
+	case providerAzure:
+		return newAzureProvider(config.Azure.BaseURL), nil
+	default:
+		return nil, fmt.Errorf("unknown provider: %q (must be aws, gcp or azure)", config.Provider)
+	}
+}
+
+// awsProvider はEC2のIMDS (spot/instance-action) で中断通知を取得する
+type awsProvider struct {
+	metadata *imds.Client
+}
+
+func (p *awsProvider) Name() string {
+	return fmt.Sprintf("aws (%s)", p.metadata.BaseURL())
+}
+
+func (p *awsProvider) InstanceID(ctx context.Context) (string, error) {
+	return p.metadata.InstanceID(ctx)
+}
+
+func (p *awsProvider) AvailabilityZone(ctx context.Context) (string, error) {
+	return p.metadata.AvailabilityZone(ctx)
+}
+
+func (p *awsProvider) InstanceType(ctx context.Context) (string, error) {
+	return p.metadata.InstanceType(ctx)
+}
+
+func (p *awsProvider) Check(ctx context.Context) (*InstanceAction, error) {
+	spotAction, err := p.metadata.SpotInstanceAction(ctx)
+	if err != nil {
+		// ネットワークエラーなど (リトライはIMDSクライアントが行う)
+		return nil, fmt.Errorf("failed to get instance-action: %w", err)
+	}
+	if spotAction == nil {
+		// 404 Not Found: 正常、中断なし
+		return nil, nil
+	}
+	return newInstanceAction(spotAction)
+}
+
+// Acknowledge はEC2では不要
+func (p *awsProvider) Acknowledge(ctx context.Context, action *InstanceAction) error {
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAzureMetadataURL = "http://169.254.169.254"
+	azureScheduledEventsURL = "/metadata/scheduledevents?api-version=2020-07-01"
+	azureComputeNameURL     = "/metadata/instance/compute/name?api-version=2021-02-01&format=text"
+)
+
+// azureScheduledEvents は Scheduled Events のレスポンス
+type azureScheduledEvents struct {
+	DocumentIncarnation int                   `json:"DocumentIncarnation"`
+	Events              []azureScheduledEvent `json:"Events"`
+}
+
+type azureScheduledEvent struct {
+	EventID      string   `json:"EventId"`
+	EventType    string   `json:"EventType"` // Preempt, Terminate, Reboot, Redeploy, Freeze
+	ResourceType string   `json:"ResourceType"`
+	Resources    []string `json:"Resources"`
+	EventStatus  string   `json:"EventStatus"` // Scheduled, Started
+	NotBefore    string   `json:"NotBefore"`   // 例: "Mon, 19 Sep 2016 18:29:47 GMT"。Started の場合は空
+}
+
+// azureProvider はAzureの Scheduled Events でスポットVMの削除 (Preempt) と Terminate を検知する
+// シャットダウン処理が終わったら StartRequests でイベントを承認し、待たずに進めてもらう
+type azureProvider struct {
+	baseURL    string
+	httpClient *http.Client
+
+	mu sync.Mutex
+	// 自分のVM名。イベントの対象かどうかの判定に使う (取得できなければ判定しない)
+	vmName       string
+	vmNameWarned bool
+	// Check で返したイベントのID (Acknowledge で承認する)
+	pending []string
+}
+
+func newAzureProvider(baseURL string) *azureProvider {
+	if baseURL == "" {
+		baseURL = defaultAzureMetadataURL
+	}
+	return &azureProvider{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (p *azureProvider) Name() string {
+	return fmt.Sprintf("azure (%s)", p.baseURL)
+}
+
+func (p *azureProvider) InstanceID(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.vmName != "" {
+		return p.vmName, nil
+	}
+	body, err := p.do(ctx, http.MethodGet, azureComputeNameURL, nil)
+	if err != nil {
+		return "", err
+	}
+	p.vmName = strings.TrimSpace(string(body))
+	return p.vmName, nil
+}
+
+func (p *azureProvider) Check(ctx context.Context) (*InstanceAction, error) {
+	body, err := p.do(ctx, http.MethodGet, azureScheduledEventsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	var doc azureScheduledEvents
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode scheduled events: %w", err)
+	}
+
+	vmName, err := p.InstanceID(ctx)
+	if err != nil && !p.vmNameWarned {
+		log.Printf("Could not get Azure VM name: %v. Treating all events as targeting this VM.", err)
+		p.vmNameWarned = true
+	}
+
+	var action *InstanceAction
+	var ids []string
+	for _, ev := range doc.Events {
+		if ev.EventType != "Preempt" && ev.EventType != "Terminate" {
+			continue
+		}
+		if vmName != "" && len(ev.Resources) > 0 && !slices.Contains(ev.Resources, vmName) {
+			continue
+		}
+
+		// 開始済みのイベントは NotBefore が空になる
+		deadline := time.Now()
+		if ev.NotBefore != "" {
+			deadline, err = time.Parse(time.RFC1123, ev.NotBefore)
+			if err != nil {
+				return nil, fmt.Errorf("invalid NotBefore of scheduled event %s: %w", ev.EventID, err)
+			}
+		}
+		if action == nil || deadline.Before(action.Time) {
+			action = &InstanceAction{Action: ActionTerminate, Time: deadline}
+		}
+		ids = append(ids, ev.EventID)
+	}
+	// 承認すると NotBefore が空になり、中断時刻が変わってしまうため、イベントのIDで識別する
+	if action != nil {
+		action.ID = strings.Join(slices.Sorted(slices.Values(ids)), ",")
+	}
+
+	p.mu.Lock()
+	p.pending = ids
+	p.mu.Unlock()
+	return action, nil
+}
+
+// Acknowledge は Check で返したイベントを承認する
+func (p *azureProvider) Acknowledge(ctx context.Context, action *InstanceAction) error {
+	p.mu.Lock()
+	ids := p.pending
+	p.mu.Unlock()
+	if len(ids) == 0 {
+		return nil
+	}
+
+	type startRequest struct {
+		EventID string `json:"EventId"`
+	}
+	var req struct {
+		StartRequests []startRequest `json:"StartRequests"`
+	}
+	for _, id := range ids {
+		req.StartRequests = append(req.StartRequests, startRequest{EventID: id})
+	}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if _, err := p.do(ctx, http.MethodPost, azureScheduledEventsURL, payload); err != nil {
+		return fmt.Errorf("failed to acknowledge scheduled events %s: %w", strings.Join(ids, ", "), err)
+	}
+	log.Printf("Acknowledged Azure scheduled events: %s", strings.Join(ids, ", "))
+	return nil
+}
+
+func (p *azureProvider) do(ctx context.Context, method, path string, payload []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metadata request: %w", err)
+	}
+	// Azure IMDSはこのヘッダーがないリクエストを拒否する
+	req.Header.Set("Metadata", "true")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{Path: path, StatusCode: resp.StatusCode}
+	}
+	return body, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAzureIMDS は Scheduled Events とVM名を返し、承認されたイベントを記録する
+// 本物と同じく、承認したイベントは開始済みになり NotBefore が空になる
+type fakeAzureIMDS struct {
+	vmName string
+
+	mu           sync.Mutex
+	events       []azureScheduledEvent
+	acknowledged [][]string
+}
+
+func (f *fakeAzureIMDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Metadata") != "true" {
+		http.Error(w, "missing Metadata header", http.StatusBadRequest)
+		return
+	}
+	switch r.URL.Path {
+	case "/metadata/instance/compute/name":
+		fmt.Fprint(w, f.vmName)
+	case "/metadata/scheduledevents":
+		if r.Method == http.MethodPost {
+			var req struct {
+				StartRequests []struct {
+					EventID string `json:"EventId"`
+				} `json:"StartRequests"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var ids []string
+			for _, s := range req.StartRequests {
+				ids = append(ids, s.EventID)
+			}
+			f.mu.Lock()
+			f.acknowledged = append(f.acknowledged, ids)
+			for i := range f.events {
+				if slices.Contains(ids, f.events[i].EventID) {
+					f.events[i].EventStatus = "Started"
+					f.events[i].NotBefore = ""
+				}
+			}
+			f.mu.Unlock()
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		json.NewEncoder(w).Encode(azureScheduledEvents{DocumentIncarnation: 1, Events: f.events})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestAzureProviderCheckAndAcknowledge(t *testing.T) {
+	soon := time.Now().Add(30 * time.Second).UTC().Truncate(time.Second)
+	later := soon.Add(time.Minute)
+	fake := &fakeAzureIMDS{
+		vmName: "minecraft-vm",
+		events: []azureScheduledEvent{
+			{EventID: "preempt-later", EventType: "Preempt", Resources: []string{"minecraft-vm"}, EventStatus: "Scheduled", NotBefore: later.Format(time.RFC1123)},
+			{EventID: "terminate-soon", EventType: "Terminate", Resources: []string{"minecraft-vm"}, EventStatus: "Scheduled", NotBefore: soon.Format(time.RFC1123)},
+			// 対象外のイベント
+			{EventID: "reboot", EventType: "Reboot", Resources: []string{"minecraft-vm"}, EventStatus: "Scheduled", NotBefore: soon.Format(time.RFC1123)},
+			{EventID: "other-vm", EventType: "Preempt", Resources: []string{"other-vm"}, EventStatus: "Scheduled", NotBefore: soon.Format(time.RFC1123)},
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	p := newAzureProvider(server.URL)
+	action, err := p.Check(t.Context())
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if action == nil {
+		t.Fatal("Check returned no action")
+	}
+	if action.Action != ActionTerminate {
+		t.Errorf("Action = %q, want %q", action.Action, ActionTerminate)
+	}
+	// 複数のイベントがある場合は一番早いものに合わせる
+	if !action.Time.Equal(soon) {
+		t.Errorf("Time = %s, want %s", action.Time, soon)
+	}
+
+	if err := p.Acknowledge(t.Context(), action); err != nil {
+		t.Fatalf("Acknowledge: %v", err)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.acknowledged) != 1 {
+		t.Fatalf("acknowledged %d times, want 1", len(fake.acknowledged))
+	}
+	got := slices.Sorted(slices.Values(fake.acknowledged[0]))
+	want := []string{"preempt-later", "terminate-soon"}
+	if !slices.Equal(got, want) {
+		t.Errorf("acknowledged events = %v, want %v", got, want)
+	}
+}
+
+func TestAzureProviderStartedEvent(t *testing.T) {
+	// 開始済みのイベントは NotBefore が空で、すぐに停止される
+	fake := &fakeAzureIMDS{
+		vmName: "minecraft-vm",
+		events: []azureScheduledEvent{
+			{EventID: "started", EventType: "Preempt", Resources: []string{"minecraft-vm"}, EventStatus: "Started"},
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	before := time.Now()
+	action, err := newAzureProvider(server.URL).Check(t.Context())
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if action == nil || action.Time.Before(before) || action.Time.After(time.Now()) {
+		t.Errorf("action = %v, want a deadline of now", action)
+	}
+}
+
+func TestAzureProviderNoEvents(t *testing.T) {
+	fake := &fakeAzureIMDS{vmName: "minecraft-vm"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	p := newAzureProvider(server.URL)
+	action, err := p.Check(t.Context())
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if action != nil {
+		t.Fatalf("action = %v, want nil", action)
+	}
+	// 承認するイベントがない場合はリクエストを送らない
+	if err := p.Acknowledge(t.Context(), nil); err != nil {
+		t.Fatalf("Acknowledge: %v", err)
+	}
+	if len(fake.acknowledged) != 0 {
+		t.Errorf("acknowledged %v, want nothing", fake.acknowledged)
+	}
+}
+
+func TestAzureProviderInvalidNotBefore(t *testing.T) {
+	fake := &fakeAzureIMDS{
+		vmName: "minecraft-vm",
+		events: []azureScheduledEvent{
+			{EventID: "bad", EventType: "Preempt", EventStatus: "Scheduled", NotBefore: "tomorrow"},
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	if _, err := newAzureProvider(server.URL).Check(t.Context()); err == nil {
+		t.Error("Check succeeded, want an error for the invalid NotBefore")
+	}
+}
+
+func TestAzureProviderKeySurvivesAcknowledge(t *testing.T) {
+	fake := &fakeAzureIMDS{
+		vmName: "minecraft-vm",
+		events: []azureScheduledEvent{
+			{EventID: "preempt", EventType: "Preempt", Resources: []string{"minecraft-vm"}, EventStatus: "Scheduled",
+				NotBefore: time.Now().Add(30 * time.Second).UTC().Format(time.RFC1123)},
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	state, err := loadState(statePath)
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	p := newAzureProvider(server.URL)
+	first, err := checkInterruption(t.Context(), p, state)
+	if err != nil || first == nil {
+		t.Fatalf("checkInterruption = %v, %v", first, err)
+	}
+	rec, _ := state.beginInterruption(first)
+	rec.Done = true
+	if err := state.save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if err := p.Acknowledge(t.Context(), first); err != nil {
+		t.Fatalf("Acknowledge: %v", err)
+	}
+
+	// 承認した後に再起動すると NotBefore は空になっているが、同じ中断通知とわかる
+	state, err = loadState(statePath)
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	second, err := checkInterruption(t.Context(), newAzureProvider(server.URL), state)
+	if err != nil || second == nil {
+		t.Fatalf("checkInterruption after restart = %v, %v", second, err)
+	}
+	if interruptionKey(second) != interruptionKey(first) {
+		t.Errorf("key after restart = %q, want %q", interruptionKey(second), interruptionKey(first))
+	}
+	if !state.handled(second) {
+		t.Error("interruption handled before the restart was not reported as handled")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGCPMetadataURL = "http://metadata.google.internal"
+	gcpPreemptedPath      = "/computeMetadata/v1/instance/preempted"
+	gcpInstanceIDPath     = "/computeMetadata/v1/instance/id"
+
+	// プリエンプトの通知から停止までの時間 (GCPの仕様)
+	gcpPreemptionNotice = 30 * time.Second
+	// wait_for_change で1回に待つ最大時間
+	gcpWaitTimeout = 60 * time.Second
+	// エラー時に待ち直すまでの時間
+	gcpRetryInterval = 5 * time.Second
+)
+
+// gcpProvider はGCPのメタデータサーバーの instance/preempted で中断を検知する
+// この値は変化するまで応答を返さない (wait_for_change) ため、バックグラウンドで待ち続け、
+// Check では最後に受け取った値を返す
+type gcpProvider struct {
+	baseURL    string
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	preempted *InstanceAction
+	lastErr   error
+	cancel    context.CancelFunc
+}
+
+func newGCPProvider(baseURL string) *gcpProvider {
+	if baseURL == "" {
+		baseURL = defaultGCPMetadataURL
+	}
+	return &gcpProvider{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		// wait_for_change の待ち時間より少し長くする
+		httpClient: &http.Client{Timeout: gcpWaitTimeout + 10*time.Second},
+	}
+}
+
+func (p *gcpProvider) Name() string {
+	return fmt.Sprintf("gcp (%s)", p.baseURL)
+}
+
+// start はプリエンプトの監視を開始する
+func (p *gcpProvider) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	go p.watch(ctx)
+}
+
+// close は監視を止める (設定の再読み込みでプロバイダーを作り直す場合)
+func (p *gcpProvider) close() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+}
+
+func (p *gcpProvider) watch(ctx context.Context) {
+	etag := ""
+	for {
+		value, newETag, err := p.get(ctx, gcpPreemptedPath, etag)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			p.mu.Lock()
+			if p.lastErr == nil {
+				log.Printf("Error watching GCP preemption: %v. Retrying in %s.", err, gcpRetryInterval)
+			}
+			p.lastErr = err
+			p.mu.Unlock()
+			// ETagが古くなっている可能性があるので、最初から取り直す
+			etag = ""
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(gcpRetryInterval):
+			}
+			continue
+		}
+		etag = newETag
+
+		p.mu.Lock()
+		p.lastErr = nil
+		if value == "TRUE" && p.preempted == nil {
+			// 通知には時刻が含まれないため、受け取った時刻から停止までの時間を見積もる
+			// 再起動しても同じ時刻になるよう、main で最初に検知した時刻に揃える
+			p.preempted = &InstanceAction{Action: ActionTerminate, Time: time.Now().Add(gcpPreemptionNotice), Notice: gcpPreemptionNotice}
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *gcpProvider) Check(ctx context.Context) (*InstanceAction, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.preempted != nil {
+		// 呼び出し側で Time と ID を書き換えるため、コピーを返す
+		action := *p.preempted
+		return &action, nil
+	}
+	if p.lastErr != nil {
+		return nil, fmt.Errorf("failed to watch instance/preempted: %w", p.lastErr)
+	}
+	return nil, nil
+}
+
+func (p *gcpProvider) InstanceID(ctx context.Context) (string, error) {
+	id, _, err := p.get(ctx, gcpInstanceIDPath, "")
+	return id, err
+}
+
+// Acknowledge はGCPでは不要
+func (p *gcpProvider) Acknowledge(ctx context.Context, action *InstanceAction) error {
+	return nil
+}
+
+// get はメタデータを取得する。lastETag を指定すると値が変わるまで待つ
+func (p *gcpProvider) get(ctx context.Context, path, lastETag string) (value, etag string, err error) {
+	u := p.baseURL + path
+	if lastETag != "" {
+		q := url.Values{}
+		q.Set("wait_for_change", "true")
+		q.Set("last_etag", lastETag)
+		q.Set("timeout_sec", fmt.Sprint(int(gcpWaitTimeout.Seconds())))
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create metadata request: %w", err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", &statusError{Path: path, StatusCode: resp.StatusCode}
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	etag = resp.Header.Get("ETag")
+	if etag == "" && path == gcpPreemptedPath {
+		return "", "", errors.New("metadata server did not return an ETag")
+	}
+	return strings.TrimSpace(string(body)), etag, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForCheck は Check が done を満たすまで繰り返す (gcpProvider はバックグラウンドで監視するため)
+func waitForCheck(t *testing.T, p *gcpProvider, done func(*InstanceAction, error) bool) (*InstanceAction, error) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		action, err := p.Check(t.Context())
+		if done(action, err) {
+			return action, err
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Check did not change in time: action=%v err=%v", action, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGCPProviderWaitsForChange(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing Metadata-Flavor", http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != gcpPreemptedPath {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		queries = append(queries, r.URL.RawQuery)
+		n := len(queries)
+		mu.Unlock()
+
+		switch n {
+		case 1:
+			// 最初は wait_for_change なしで今の値を返す
+			w.Header().Set("ETag", "etag-1")
+			fmt.Fprint(w, "FALSE")
+		case 2:
+			w.Header().Set("ETag", "etag-2")
+			fmt.Fprint(w, "TRUE")
+		default:
+			// 値が変わらないまま待ち続ける
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	p := newGCPProvider(server.URL)
+	p.start()
+	defer p.close()
+
+	before := time.Now()
+	action, err := waitForCheck(t, p, func(a *InstanceAction, err error) bool { return a != nil || err != nil })
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if action.Action != ActionTerminate {
+		t.Errorf("Action = %q, want %q", action.Action, ActionTerminate)
+	}
+	if action.Time.Before(before.Add(gcpPreemptionNotice)) || action.Time.After(time.Now().Add(gcpPreemptionNotice)) {
+		t.Errorf("Time = %s, want about %s from now", action.Time, gcpPreemptionNotice)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if queries[0] != "" {
+		t.Errorf("first request query = %q, want none", queries[0])
+	}
+	want := "last_etag=etag-1&timeout_sec=60&wait_for_change=true"
+	if queries[1] != want {
+		t.Errorf("second request query = %q, want %q", queries[1], want)
+	}
+}
+
+func TestGCPProviderReportsWatchErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		check   func(t *testing.T, err error)
+	}{
+		{
+			name: "server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			},
+			check: func(t *testing.T, err error) {
+				var statusErr *statusError
+				if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+					t.Errorf("err = %v, want statusError 503", err)
+				}
+				if !transientError(err) {
+					t.Errorf("transientError(%v) = false, want true", err)
+				}
+			},
+		},
+		{
+			name: "missing ETag",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "FALSE")
+			},
+			check: func(t *testing.T, err error) {
+				if err == nil {
+					t.Error("err = nil, want an error about the missing ETag")
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			p := newGCPProvider(server.URL)
+			p.start()
+			defer p.close()
+
+			action, err := waitForCheck(t, p, func(a *InstanceAction, err error) bool { return a != nil || err != nil })
+			if action != nil {
+				t.Fatalf("action = %v, want nil", action)
+			}
+			tt.check(t, err)
+		})
+	}
+}
+
+func TestGCPProviderInstanceID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != gcpInstanceIDPath || r.Header.Get("Metadata-Flavor") != "Google" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "1234567890\n")
+	}))
+	defer server.Close()
+
+	id, err := newGCPProvider(server.URL).InstanceID(t.Context())
+	if err != nil {
+		t.Fatalf("InstanceID: %v", err)
+	}
+	if id != "1234567890" {
+		t.Errorf("InstanceID = %q, want %q", id, "1234567890")
+	}
+}
+
+// preemptedServer は最初から instance/preempted が TRUE のメタデータサーバー
+func preemptedServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("wait_for_change") != "" {
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("ETag", "etag-1")
+		fmt.Fprint(w, "TRUE")
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// checkGCP は新しく起動したハンドラーと同じように、状態ファイルを読み込んで中断通知を取得する
+func checkGCP(t *testing.T, url, statePath string) (*InstanceAction, *handlerState) {
+	t.Helper()
+	state, err := loadState(statePath)
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	p := newGCPProvider(url)
+	p.start()
+	defer p.close()
+	waitForCheck(t, p, func(a *InstanceAction, err error) bool { return a != nil || err != nil })
+	action, err := checkInterruption(t.Context(), p, state)
+	if err != nil {
+		t.Fatalf("checkInterruption: %v", err)
+	}
+	return action, state
+}
+
+func TestGCPProviderKeySurvivesRestart(t *testing.T) {
+	server := preemptedServer(t)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	first, state := checkGCP(t, server.URL, statePath)
+	rec, _ := state.beginInterruption(first)
+	rec.Done = true
+	if err := state.save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// 再起動すると検知する時刻が変わるが、最初に検知した時刻で同じ中断通知とわかる
+	time.Sleep(20 * time.Millisecond)
+	second, state := checkGCP(t, server.URL, statePath)
+	if interruptionKey(second) != interruptionKey(first) {
+		t.Errorf("key after restart = %q, want %q", interruptionKey(second), interruptionKey(first))
+	}
+	if !second.Time.Equal(first.Time) {
+		t.Errorf("Time after restart = %s, want %s", second.Time, first.Time)
+	}
+	if !state.handled(second) {
+		t.Error("interruption handled before the restart was not reported as handled")
+	}
+}
+
+func TestGCPProviderIgnoresOldDetection(t *testing.T) {
+	server := preemptedServer(t)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	// 以前に起動していたときに検知した中断通知
+	old := &handlerState{path: statePath, FirstDetectedAt: time.Now().Add(-time.Hour).UTC()}
+	if err := old.save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	action, state := checkGCP(t, server.URL, statePath)
+	if !state.FirstDetectedAt.After(old.FirstDetectedAt) {
+		t.Errorf("FirstDetectedAt = %s, want a new detection time", state.FirstDetectedAt)
+	}
+	if left := action.TimeLeft(time.Now()); left <= 0 || left > gcpPreemptionNotice {
+		t.Errorf("time left = %s, want up to %s", left, gcpPreemptionNotice)
+	}
+	// 検知した時刻は処理を始める前に保存されている
+	saved, err := loadState(statePath)
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if !saved.FirstDetectedAt.Equal(state.FirstDetectedAt) {
+		t.Errorf("saved FirstDetectedAt = %s, want %s", saved.FirstDetectedAt, state.FirstDetectedAt)
+	}
+}
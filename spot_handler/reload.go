@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"common/imds"
+)
+
+// watchers は設定から作成したメタデータのクライアントと監視
+// SIGHUP で設定を再読み込みしたときに作り直す
+type watchers struct {
+	config   *Config
+	md       *imds.Client
+	provider interruptionProvider
+	history  *historyLog
+	state    *handlerState
+
+	// メンテナンスイベントは中断通知より長い間隔でチェックする
+	maintenance       *maintenanceWatcher
+	maintenanceTicker *time.Ticker
+}
+
+func newWatchers(config *Config, state *handlerState) *watchers {
+	w := &watchers{history: &historyLog{}, state: state}
+	w.apply(config)
+	return w
+}
+
+// apply は設定に従ってクライアントと監視を作成する
+// 予約済みのメンテナンスのお知らせは引き継ぐ
+func (w *watchers) apply(config *Config) {
+	if w.provider != nil {
+		closeProvider(w.provider)
+	}
+	w.config = config
+	w.md = config.IMDS.newClient()
+	// 設定は検証済みのため、ここでは失敗しない
+	provider, err := newInterruptionProvider(config, w.md)
+	if err != nil {
+		log.Fatalf("Fatal: %v", err)
+	}
+	w.provider = provider
+	w.history.config = config
+	w.history.instance = provider
+	log.Printf("Watching for interruptions with provider %s", provider.Name())
+	stats.setConfig(config.pollingInterval, provider.Name())
+
+	if w.maintenanceTicker != nil {
+		w.maintenanceTicker.Stop()
+		w.maintenanceTicker = nil
+	}
+	if !config.Maintenance.Enabled {
+		if w.maintenance != nil {
+			w.maintenance.stop()
+			w.maintenance = nil
+		}
+		return
+	}
+	if w.maintenance == nil {
+		w.maintenance = newMaintenanceWatcher(config, w.md, w.history, w.state)
+	} else {
+		w.maintenance.reload(config, w.md)
+	}
+	w.pollMaintenance()
+	w.maintenanceTicker = time.NewTicker(config.Maintenance.pollingInterval)
+}
+
+// maintenanceTick はメンテナンスイベントをチェックするタイミングを返す (無効な場合はnil)
+func (w *watchers) maintenanceTick() <-chan time.Time {
+	if w.maintenanceTicker == nil {
+		return nil
+	}
+	return w.maintenanceTicker.C
+}
+
+func (w *watchers) pollMaintenance() {
+	if err := w.maintenance.poll(context.Background()); err != nil {
+		log.Printf("Error checking for scheduled maintenance: %v", err)
+	}
+}
+
+func (w *watchers) close() {
+	closeProvider(w.provider)
+	if w.maintenanceTicker != nil {
+		w.maintenanceTicker.Stop()
+	}
+	if w.maintenance != nil {
+		w.maintenance.stop()
+	}
+}
+
+// reloadConfig は設定ファイルを読み直して検証する
+// 新しい設定が不正な場合はエラーを返し、呼び出し側は今の設定を使い続ける
+// ステータスサーバーの待ち受けアドレスは再起動するまで変更できない
+func reloadConfig(path string, current *Config) (*Config, error) {
+	config, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if config.Status.Listen != current.Status.Listen {
+		log.Printf("status.listen cannot be changed without a restart. Keeping %q.", current.Status.Listen)
+		config.Status = current.Status
+	}
+	return config, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// runReport は report サブコマンド
+// 履歴をアベイラビリティーゾーン、インスタンスタイプ、月ごとに集計して表示する
+//
+//	spot-handler report [-config path] [-s3]
+func runReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "Path to the configuration file")
+	fromS3 := fs.Bool("s3", false, "Read the history of every instance from S3 instead of the local file")
+	fs.Parse(args)
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("could not load config from %s: %w", *configPath, err)
+	}
+
+	var entries []historyEntry
+	if *fromS3 {
+		if config.History.S3Bucket == "" {
+			return fmt.Errorf("history.s3Bucket is not set")
+		}
+		entries, err = readHistoryFromS3(context.Background(), config.History, "")
+	} else {
+		entries, err = readHistory(config.History.File)
+	}
+	if err != nil {
+		return err
+	}
+	printReport(os.Stdout, entries)
+	return nil
+}
+
+// readHistoryFromS3 は s3Prefix の下にある全てのインスタンスの履歴を読み込む
+// zone はリージョンを設定していない場合にリージョンを決めるために使う (わからない場合は空文字列)
+func readHistoryFromS3(ctx context.Context, cfg HistoryConfig, zone string) ([]historyEntry, error) {
+	client, err := newHistoryS3Client(ctx, cfg, zone)
+	if err != nil {
+		return nil, err
+	}
+	var entries []historyEntry
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(cfg.S3Bucket),
+		Prefix: aws.String(cfg.S3Prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list s3://%s/%s: %w", cfg.S3Bucket, cfg.S3Prefix, err)
+		}
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(cfg.S3Bucket), Key: object.Key})
+			if err != nil {
+				return nil, fmt.Errorf("failed to download s3://%s/%s: %w", cfg.S3Bucket, key, err)
+			}
+			decoded, err := decodeHistory(out.Body, "s3://"+cfg.S3Bucket+"/"+key)
+			out.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, decoded...)
+		}
+	}
+	return entries, nil
+}
+
+// reportRow は集計の1行分
+type reportRow struct {
+	interruptions int
+	rebalances    int
+	maintenance   int
+	// 中断されたときの起動からの時間とシャットダウン処理の時間の合計 (平均を出すため)
+	uptime          time.Duration
+	uptimeCount     int
+	shutdown        time.Duration
+	shutdownCount   int
+	failedShutdowns int
+}
+
+func (r *reportRow) add(entry historyEntry) {
+	switch entry.Event {
+	case historyInterruption:
+		r.interruptions++
+		if entry.UptimeSeconds > 0 {
+			r.uptime += seconds(entry.UptimeSeconds)
+			r.uptimeCount++
+		}
+		// 以前の履歴ではシャットダウン処理の結果も interruption に記録していた
+		r.addShutdown(entry)
+	case historyShutdown:
+		r.addShutdown(entry)
+	case historyRebalance:
+		r.rebalances++
+	case historyMaintenance:
+		r.maintenance++
+	}
+}
+
+func (r *reportRow) addShutdown(entry historyEntry) {
+	if entry.PipelineSeconds > 0 {
+		r.shutdown += seconds(entry.PipelineSeconds)
+		r.shutdownCount++
+	}
+	if entry.PipelineFailed > 0 {
+		r.failedShutdowns++
+	}
+}
+
+// printReport はアベイラビリティーゾーン、インスタンスタイプ、月ごとの集計を表示する
+func printReport(w io.Writer, entries []historyEntry) {
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No history recorded yet.")
+		return
+	}
+	first, last := entries[0].Time, entries[0].Time
+	for _, entry := range entries {
+		if entry.Time.Before(first) {
+			first = entry.Time
+		}
+		if entry.Time.After(last) {
+			last = entry.Time
+		}
+	}
+	fmt.Fprintf(w, "%d events from %s to %s\n", len(entries), first.Format(time.DateOnly), last.Format(time.DateOnly))
+
+	groups := []struct {
+		title string
+		key   func(historyEntry) string
+	}{
+		{"Availability zone", func(e historyEntry) string { return e.AvailabilityZone }},
+		{"Instance type", func(e historyEntry) string { return e.InstanceType }},
+		{"Month", func(e historyEntry) string { return e.Time.UTC().Format("2006-01") }},
+	}
+	for _, group := range groups {
+		rows := make(map[string]*reportRow)
+		for _, entry := range entries {
+			key := group.key(entry)
+			if key == "" {
+				key = "(unknown)"
+			}
+			if rows[key] == nil {
+				rows[key] = &reportRow{}
+			}
+			rows[key].add(entry)
+		}
+		keys := make([]string, 0, len(rows))
+		for key := range rows {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintln(w)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "%s\tINTERRUPTIONS\tREBALANCES\tMAINTENANCE\tAVG UPTIME\tAVG SHUTDOWN\tFAILED SHUTDOWNS\n", group.title)
+		for _, key := range keys {
+			r := rows[key]
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\t%d\n", key, r.interruptions, r.rebalances, r.maintenance,
+				average(r.uptime, r.uptimeCount, time.Minute), average(r.shutdown, r.shutdownCount, time.Second), r.failedShutdowns)
+		}
+		tw.Flush()
+	}
+}
+
+// average は平均を round で丸めて返す (件数が0の場合は "-")
+func average(total time.Duration, n int, round time.Duration) string {
+	if n == 0 {
+		return "-"
+	}
+	return (total / time.Duration(n)).Round(round).String()
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// 高リスクモードのデフォルト
+const (
+	defaultHighRiskSaveInterval       = time.Minute
+	defaultHighRiskQuietPeriod        = 6 * time.Hour
+	defaultHighRiskZoneInterruptions  = 2
+	defaultHighRiskZoneWindow         = 7 * 24 * time.Hour
+	defaultHighRiskNormalSaveInterval = 5 * time.Minute
+	// save-all 1回にかける時間
+	highRiskSaveTimeout = 30 * time.Second
+)
+
+// HighRiskConfig は中断されそうなときに保存の間隔を短くする高リスクモードの設定
+type HighRiskConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// 高リスクモードの間に save-all を実行する間隔
+	SaveInterval string `yaml:"saveInterval"`
+	// 最後のきっかけからこの時間が経ったら通常のモードに戻る
+	QuietPeriod string `yaml:"quietPeriod"`
+	// 同じアベイラビリティーゾーンで zoneWindow の間にこの回数中断されていたら高リスクモードで起動する
+	ZoneInterruptions int    `yaml:"zoneInterruptions"`
+	ZoneWindow        string `yaml:"zoneWindow"`
+	// 通常のモードで保存される間隔 (サーバー自身の自動保存の間隔)。失う可能性のあるデータの目安としてログに出す
+	NormalSaveInterval string `yaml:"normalSaveInterval"`
+
+	saveInterval       time.Duration
+	quietPeriod        time.Duration
+	zoneWindow         time.Duration
+	normalSaveInterval time.Duration
+}
+
+// parse は文字列で書かれた時間をパースし、未設定の項目にデフォルト値を入れる
+func (c *HighRiskConfig) parse() error {
+	durations := []struct {
+		name  string
+		value string
+		dst   *time.Duration
+		def   time.Duration
+	}{
+		{"saveInterval", c.SaveInterval, &c.saveInterval, defaultHighRiskSaveInterval},
+		{"quietPeriod", c.QuietPeriod, &c.quietPeriod, defaultHighRiskQuietPeriod},
+		{"zoneWindow", c.ZoneWindow, &c.zoneWindow, defaultHighRiskZoneWindow},
+		{"normalSaveInterval", c.NormalSaveInterval, &c.normalSaveInterval, defaultHighRiskNormalSaveInterval},
+	}
+	for _, d := range durations {
+		*d.dst = d.def
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("invalid highRisk %s: %w", d.name, err)
+		}
+		if v <= 0 {
+			return fmt.Errorf("highRisk %s must be positive: %s", d.name, d.value)
+		}
+		*d.dst = v
+	}
+	if c.ZoneInterruptions == 0 {
+		c.ZoneInterruptions = defaultHighRiskZoneInterruptions
+	}
+	return nil
+}
+
+// riskMode は高リスクモードの状態
+// 高リスクモードの間は save-all を短い間隔で実行し、中断されたときに失うデータを減らす
+// 最後のきっかけ (リバランス推奨など) から quietPeriod が経ったら通常のモードに戻る
+type riskMode struct {
+	config *Config
+
+	active bool
+	reason string
+	since  time.Time
+	until  time.Time
+	ticker *time.Ticker
+
+	// 最後に保存に成功した時刻と、保存の間隔の最大値 (中断されていたら失っていたデータの量)
+	lastSave   time.Time
+	longestGap time.Duration
+	saves      int
+	failures   int
+}
+
+func newRiskMode(config *Config) *riskMode {
+	return &riskMode{config: config}
+}
+
+// raise は高リスクモードに入る。既に高リスクモードの場合は通常に戻る時刻を延ばす
+// at はきっかけが起きた時刻 (リバランス推奨の noticeTime など)
+func (m *riskMode) raise(reason string, at time.Time) {
+	cfg := m.config.HighRisk
+	if !cfg.Enabled {
+		return
+	}
+	until := at.Add(cfg.quietPeriod)
+	if !until.After(time.Now()) {
+		return
+	}
+	if m.active {
+		if until.After(m.until) {
+			m.until = until
+			log.Printf("High-risk mode extended until %s (%s).", until.Format(time.RFC3339), reason)
+		}
+		return
+	}
+
+	now := time.Now()
+	m.active = true
+	m.reason = reason
+	m.since = now
+	m.until = until
+	m.lastSave = now
+	m.longestGap = 0
+	m.saves = 0
+	m.failures = 0
+	m.ticker = time.NewTicker(cfg.saveInterval)
+	stats.setHighRisk(true)
+	log.Printf("Entering high-risk mode (%s) until %s. Saving every %s: up to %s of progress could be lost (%s in normal mode).",
+		reason, until.Format(time.RFC3339), cfg.saveInterval, cfg.saveInterval, cfg.normalSaveInterval)
+}
+
+// tick は save-all を実行するタイミングを返す (通常のモードではnil)
+func (m *riskMode) tick() <-chan time.Time {
+	if m.ticker == nil {
+		return nil
+	}
+	return m.ticker.C
+}
+
+// save は save-all を実行する。quietPeriod が経っていた場合は通常のモードに戻る
+func (m *riskMode) save() {
+	if !m.active {
+		return
+	}
+	if !time.Now().Before(m.until) {
+		m.leave("quiet period elapsed")
+		m.logNormalMode()
+		return
+	}
+
+	server := newMinecraftServer(m.config)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), highRiskSaveTimeout)
+	defer cancel()
+	if _, err := server.Execute(ctx, "save-all"); err != nil {
+		// サーバーが止まっている間などは失敗する。次のタイミングでやり直す
+		m.failures++
+		log.Printf("High-risk autosave failed: %v. Last successful save was %s ago.", err, time.Since(m.lastSave).Round(time.Second))
+		return
+	}
+	now := time.Now()
+	if gap := now.Sub(m.lastSave); gap > m.longestGap {
+		m.longestGap = gap
+	}
+	m.lastSave = now
+	m.saves++
+}
+
+// leave は通常のモードに戻り、高リスクモードの間に失う可能性があったデータの量をログに出す
+func (m *riskMode) leave(reason string) {
+	if !m.active {
+		return
+	}
+	// 最後の保存から今までも、中断されていたら失っていた分に含める
+	gap := max(m.longestGap, time.Since(m.lastSave))
+	log.Printf("Leaving high-risk mode (%s) after %s: %d saves, %d failed. Longest time without a save was %s.",
+		reason, time.Since(m.since).Round(time.Second), m.saves, m.failures, gap.Round(time.Second))
+	m.active = false
+	m.ticker.Stop()
+	m.ticker = nil
+	stats.setHighRisk(false)
+}
+
+// logNormalMode は通常のモードで失う可能性のあるデータの量をログに出す
+func (m *riskMode) logNormalMode() {
+	log.Printf("Back to normal mode. Relying on the server autosave: up to %s of progress could be lost.", m.config.HighRisk.normalSaveInterval)
+}
+
+// reload は再読み込みした設定を反映する
+func (m *riskMode) reload(config *Config) {
+	m.config = config
+	if !m.active {
+		return
+	}
+	if !config.HighRisk.Enabled {
+		m.leave("disabled by config")
+		m.logNormalMode()
+		return
+	}
+	m.ticker.Reset(config.HighRisk.saveInterval)
+}
+
+// checkZoneHistory は今のアベイラビリティーゾーンで最近何度も中断されていた場合に高リスクモードに入る
+func (m *riskMode) checkZoneHistory(zone string) {
+	cfg := m.config.HighRisk
+	if !cfg.Enabled || zone == "" {
+		return
+	}
+	entries, err := m.zoneHistory(zone)
+	if err != nil {
+		log.Printf("Could not read interruption history: %v", err)
+		return
+	}
+	since := time.Now().Add(-cfg.zoneWindow)
+	recent := 0
+	for _, entry := range entries {
+		if entry.Event == historyInterruption && entry.AvailabilityZone == zone && !entry.Time.Before(since) {
+			recent++
+		}
+	}
+	if recent < cfg.ZoneInterruptions {
+		return
+	}
+	// 同じゾーンにいる間は危ないため、起動した時点から quietPeriod の間は高リスクモードにする
+	m.raise(fmt.Sprintf("%d interruptions in %s within %s", recent, zone, cfg.zoneWindow), time.Now())
+}
+
+// zoneHistory は中断の履歴を読み込む
+// 置き換えで起動したインスタンスには手元の履歴がないため、S3にコピーしている場合は全てのインスタンスの履歴を使う
+// S3から読めない場合は手元の履歴ファイルで判断する
+func (m *riskMode) zoneHistory(zone string) ([]historyEntry, error) {
+	cfg := m.config.History
+	if cfg.S3Bucket != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), historyTimeout)
+		defer cancel()
+		entries, err := readHistoryFromS3(ctx, cfg, zone)
+		if err == nil {
+			return entries, nil
+		}
+		log.Printf("Could not read interruption history from S3: %v. Using %s instead.", err, cfg.File)
+	}
+	return readHistory(cfg.File)
+}
+
+func (m *riskMode) stop() {
+	if m.ticker != nil {
+		m.ticker.Stop()
+	}
+}
+
+// availabilityZone はインスタンスのアベイラビリティーゾーンを返す (EC2以外や取得できない場合は空文字列)
+func availabilityZone(ctx context.Context, instance instanceIdentifier) string {
+	d, ok := instance.(instanceDescriber)
+	if !ok {
+		return ""
+	}
+	zone, err := d.AvailabilityZone(ctx)
+	if err != nil {
+		log.Printf("Could not get availability zone: %v", err)
+		return ""
+	}
+	return zone
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"common/countdown"
+	"common/imds"
+)
+
+// 各ステップのデフォルトのタイムアウト
+var defaultStepTimeouts = map[string]time.Duration{
+	"warn":      5 * time.Second,
+	"countdown": 5 * time.Second, // カウントダウン自体の長さに加える余裕
+	"save":      30 * time.Second,
+	"stop":      15 * time.Second,
+	"wait":      60 * time.Second,
+	"backup":    90 * time.Second,
+	"script":    30 * time.Second,
+	"notify":    10 * time.Second,
+	"publish":   10 * time.Second,
+}
+
+// 中断時刻を過ぎてから通知を受け取った場合でも、最低限この時間はパイプラインを実行する
+const minShutdownBudget = 30 * time.Second
+
+// カウントダウンを終えてから中断時刻までに残す、保存と停止のための時間のデフォルト
+const defaultStopMargin = 30 * time.Second
+
+// shutdownRun は中断時のシャットダウン処理1回分の状態
+type shutdownRun struct {
+	config   *Config
+	instance instanceIdentifier
+	server   *minecraftServer
+	action   *InstanceAction
+
+	pid          int  // 停止を待つJavaプロセスのPID (0なら起動していない)
+	usedFallback bool // RCONのstopに失敗してsystemctl stopを使ったか
+
+	// シミュレーションのモード (dry-run, live-notify)。空の場合は本番
+	simulate string
+}
+
+// runShutdownPipeline は中断通知を受けてサーバーを安全に停止する
+// config.yaml の hooks を順番に (parallel のグループは並列に) 実行する
+// デフォルトでは カウントダウン → save-all flush → stop → Javaプロセスの終了待ち → バックアップ → スクリプト → Discord通知 の順
+// 各ステップの完了を状態ファイルに記録し、途中で再起動した場合は完了済みのステップを飛ばして再開する
+func runShutdownPipeline(config *Config, instance instanceIdentifier, action *InstanceAction, state *handlerState) *Pipeline {
+	run := &shutdownRun{
+		config:   config,
+		instance: instance,
+		server:   newMinecraftServer(config),
+		action:   action,
+	}
+	defer run.server.Close()
+
+	ctx, cancel := context.WithDeadline(context.Background(), shutdownDeadline(action))
+	defer cancel()
+
+	// カウントダウンは保存と停止の時間を残して終える
+	countdownEnd := action.Time.Add(-config.Countdown.stopMargin)
+
+	rec, resumed := state.beginInterruption(action)
+	if resumed {
+		log.Printf("Resuming the shutdown for %s started at %s. Completed steps will be skipped.",
+			rec.Key, rec.StartedAt.Format(time.RFC3339))
+	}
+	state.saveOrLog()
+
+	p := &Pipeline{
+		Name:      "shutdown",
+		Completed: rec.completedSteps(),
+		OnStepFinished: func(result StepResult) {
+			rec.recordStep(result)
+			state.saveOrLog()
+		},
+	}
+	p.Steps = run.hookSteps(config.Hooks, p, countdownEnd)
+
+	// 開始時点でサーバーが動いているか確認しておく
+	pid, err := run.server.mainPID(ctx)
+	if err != nil {
+		log.Printf("Could not determine whether %s is running: %v", config.MinecraftService, err)
+	}
+	run.pid = pid
+	if pid == 0 {
+		log.Printf("Minecraft service (%s) is not running. Server steps will be skipped.", config.MinecraftService)
+	}
+
+	p.Run(ctx)
+
+	rec.Done = true
+	rec.FinishedAt = time.Now()
+	state.saveOrLog()
+	return p
+}
+
+// shutdownDeadline はシャットダウン処理全体の期限を返す
+// 中断時刻を期限にするが、残り時間が少ない場合も最低限の時間は確保する
+func shutdownDeadline(action *InstanceAction) time.Time {
+	deadline := action.Time
+	if min := time.Now().Add(minShutdownBudget); deadline.Before(min) {
+		deadline = min
+	}
+	return deadline
+}
+
+// rcon はRCONのコマンドの送り先を返す。シミュレーションではログに出すだけ
+func (r *shutdownRun) rcon() countdown.Commander {
+	if r.simulate != "" {
+		return logCommander{}
+	}
+	return r.server
+}
+
+func (r *shutdownRun) serverRunning() error {
+	// シミュレーションではサーバーが動いていることにして、全てのステップを通す
+	if r.pid == 0 && r.simulate == "" {
+		return fmt.Errorf("%w: %s is not running", errStepSkipped, r.config.MinecraftService)
+	}
+	return nil
+}
+
+func (r *shutdownRun) countdown(ctx context.Context, end time.Time) error {
+	if err := r.serverRunning(); err != nil {
+		return err
+	}
+	if time.Until(end) <= 0 {
+		return fmt.Errorf("%w: no time left for a countdown", errStepSkipped)
+	}
+	b := &countdown.Broadcaster{
+		Commander: r.rcon(),
+		Points:    r.config.Countdown.points,
+		Language:  r.config.Countdown.Language,
+		Reason:    countdown.ReasonSpotInterruption,
+		Logf:      log.Printf,
+	}
+	return b.Run(ctx, end)
+}
+
+func (r *shutdownRun) save(ctx context.Context) error {
+	if err := r.serverRunning(); err != nil {
+		return err
+	}
+	// flush を付けるとディスクへの書き込みが終わるまで応答が返らない
+	_, err := r.rcon().Execute(ctx, "save-all flush")
+	return err
+}
+
+func (r *shutdownRun) stop(ctx context.Context) error {
+	if err := r.serverRunning(); err != nil {
+		return err
+	}
+	_, err := r.rcon().Execute(ctx, "stop")
+	if err == nil {
+		return nil
+	}
+
+	log.Printf("Failed to send 'stop' via RCON: %v. Falling back to systemctl stop.", err)
+	r.usedFallback = true
+	if fallbackErr := r.server.stopService(ctx); fallbackErr != nil {
+		return fmt.Errorf("rcon stop failed (%v) and fallback failed: %w", err, fallbackErr)
+	}
+	return nil
+}
+
+func (r *shutdownRun) wait(ctx context.Context) error {
+	if err := r.serverRunning(); err != nil {
+		return err
+	}
+	return waitForExit(ctx, r.pid)
+}
+
+func (r *shutdownRun) backup(ctx context.Context) error {
+	if r.config.BackupCommand == "" {
+		return fmt.Errorf("%w: no backup command configured", errStepSkipped)
+	}
+	return runCommand(ctx, r.config.BackupCommand, nil, r.action.Env(time.Now()))
+}
+
+func (r *shutdownRun) script(ctx context.Context) error {
+	script := r.config.shutdownScriptFor(r.action.Action)
+	if script == "" {
+		return fmt.Errorf("%w: no script configured for action %s", errStepSkipped, r.action.Action)
+	}
+	return runCommand(ctx, "/bin/sh", []string{script}, r.action.Env(time.Now()))
+}
+
+func (r *shutdownRun) notify(ctx context.Context, p *Pipeline) error {
+	if r.config.DiscordWebhookURL == "" {
+		return fmt.Errorf("%w: discord webhook URL not set", errStepSkipped)
+	}
+
+	title := "Spot Instance Shutdown Notice"
+	message := "✅ **Shutdown completed gracefully!**\nServer has stopped due to a spot interruption."
+	color := colorSuccess
+	switch {
+	case r.pid == 0 && r.simulate == "":
+		message = "ℹ️ **Spot interruption received.**\nMinecraft server was not running."
+	case r.usedFallback:
+		message = "⚠️ **RCON command failed!**\nServer was stopped with systemctl stop as a fallback."
+		color = colorWarning
+	case p.Failed() > 0:
+		message = "⚠️ **Shutdown finished with errors.**\nCheck the spot-handler log for details."
+		color = colorWarning
+	}
+
+	fields := []discordField{
+		{Name: "Action", Value: r.action.Action, Inline: true},
+		{Name: "Deadline (UTC)", Value: r.action.Time.UTC().Format("2006-01-02 15:04:05"), Inline: true},
+		{Name: "Instance", Value: instanceIDOrNA(ctx, r.instance), Inline: true},
+		{Name: "Steps", Value: formatStepResults(p.Results), Inline: false},
+	}
+	switch r.simulate {
+	case simulateDryRun:
+		log.Printf("[simulate] Would send Discord notification %q: %s", title, strings.ReplaceAll(message, "\n", " "))
+		return nil
+	case simulateLiveNotify:
+		// 本物の中断と間違えないよう、リハーサルであることを明記する
+		title = "[Rehearsal] " + title
+		message = "🧪 This is a rehearsal. No server was stopped.\n" + message
+	}
+	return sendDiscordNotification(ctx, r.config.DiscordWebhookURL, title, message, color, fields)
+}
+
+// earlyWarning はバックグラウンドで実行中の早期警告
+// 早期警告の間もポーリングを続け、中断を検知したら取り消してシャットダウンを優先する
+type earlyWarning struct {
+	rec     *imds.Rebalance
+	started time.Time
+	cancel  context.CancelFunc
+	done    chan *Pipeline
+}
+
+// startEarlyWarning は早期警告をバックグラウンドで開始する
+func startEarlyWarning(config *Config, md *imds.Client, rec *imds.Rebalance) *earlyWarning {
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &earlyWarning{rec: rec, started: time.Now(), cancel: cancel, done: make(chan *Pipeline, 1)}
+	go func() {
+		e.done <- runEarlyWarningPipeline(ctx, config, md, rec)
+	}()
+	return e
+}
+
+// finished は早期警告が終わったときに結果を受け取るチャンネルを返す (実行中でない場合はnil)
+func (e *earlyWarning) finished() <-chan *Pipeline {
+	if e == nil {
+		return nil
+	}
+	return e.done
+}
+
+// abort は早期警告を取り消し、実行中のステップが止まるまで待つ
+// バックアップなどがシャットダウン処理と同時に動かないようにする
+func (e *earlyWarning) abort() *Pipeline {
+	e.cancel()
+	return <-e.done
+}
+
+// runEarlyWarningPipeline はリバランス推奨を受けて、中断に備えた事前処理を行う
+// ゲーム内警告 → 事前バックアップ → スクリプト → Discord通知 の順に実行する
+// ctx が取り消されると実行中のステップを止め、残りのステップは失敗として記録される
+func runEarlyWarningPipeline(ctx context.Context, config *Config, md *imds.Client, rec *imds.Rebalance) *Pipeline {
+	server := newMinecraftServer(config)
+	defer server.Close()
+	noticeTime := rec.NoticeTime.UTC().Format(time.RFC3339)
+	env := []string{"SPOT_EVENT=rebalance", "SPOT_NOTICE_TIME=" + noticeTime}
+
+	p := &Pipeline{Name: "early-warning"}
+	p.Steps = []Step{
+		{Name: "warn", Timeout: config.stepTimeout("warn"), Run: func(ctx context.Context) error {
+			_, err := server.Execute(ctx, "say §e[お知らせ] サーバーが近いうちに停止する可能性があります。安全な場所で待機してください。")
+			return err
+		}},
+		{Name: "backup", Timeout: config.stepTimeout("backup"), Run: func(ctx context.Context) error {
+			if config.BackupCommand == "" {
+				return fmt.Errorf("%w: no backup command configured", errStepSkipped)
+			}
+			// 稼働中のサーバーのバックアップになるため、バックアップツールに自動保存の停止・再開を任せる
+			return runCommand(ctx, config.BackupCommand, nil, append(env, config.RCON.Env()...))
+		}},
+		{Name: "script", Timeout: config.stepTimeout("script"), Run: func(ctx context.Context) error {
+			if config.EarlyWarningScript == "" {
+				return fmt.Errorf("%w: no early warning script configured", errStepSkipped)
+			}
+			return runCommand(ctx, "/bin/sh", []string{config.EarlyWarningScript}, env)
+		}},
+		{Name: "notify", Timeout: config.stepTimeout("notify"), Run: func(ctx context.Context) error {
+			if config.DiscordWebhookURL == "" {
+				return fmt.Errorf("%w: discord webhook URL not set", errStepSkipped)
+			}
+			fields := []discordField{
+				{Name: "Notice Time (UTC)", Value: noticeTime, Inline: true},
+				{Name: "Instance", Value: instanceIDOrNA(ctx, md), Inline: true},
+			}
+			return sendDiscordNotification(ctx, config.DiscordWebhookURL, "Spot Instance Rebalance Recommendation",
+				"⚠️ **Rebalance recommendation received.**\nThe spot instance is at elevated risk of interruption.",
+				colorInfo, fields)
+		}},
+	}
+	p.Run(ctx)
+	return p
+}
+
+// formatStepResults はステップの結果をDiscord用の短い文字列にする
+func formatStepResults(results []StepResult) string {
+	var b strings.Builder
+	for _, r := range results {
+		mark := "✅"
+		switch r.Status {
+		case "failed":
+			mark = "❌"
+		case "skipped":
+			mark = "⏭️"
+		case "aborted":
+			mark = "⛔"
+		}
+		fmt.Fprintf(&b, "%s %s (%s)\n", mark, r.Name, r.Duration.Round(100*time.Millisecond))
+	}
+	if b.Len() == 0 {
+		return "N/A"
+	}
+	return b.String()
+}
+
+// instanceIDOrNA は通知に載せるインスタンスIDを返す。取得できない場合は N/A
+func instanceIDOrNA(ctx context.Context, instance instanceIdentifier) string {
+	id, err := instance.InstanceID(ctx)
+	if err != nil || id == "" {
+		return "N/A"
+	}
+	return id
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// シミュレーションのモード (--simulate の値)
+const (
+	// 副作用のあるステップは全てログに出すだけにする
+	simulateDryRun = "dry-run"
+	// Discordの通知だけ実際に送る (リハーサル用)。それ以外は dry-run と同じ
+	simulateLiveNotify = "live-notify"
+)
+
+// simulateFlag は --simulate の値
+// --simulate だけの場合は dry-run、--simulate=live-notify で通知だけ送る
+type simulateFlag struct {
+	mode string
+}
+
+func (f *simulateFlag) String() string { return f.mode }
+
+func (f *simulateFlag) Set(value string) error {
+	switch value {
+	case "true", simulateDryRun:
+		f.mode = simulateDryRun
+	case simulateLiveNotify:
+		f.mode = simulateLiveNotify
+	case "false":
+		f.mode = ""
+	default:
+		return fmt.Errorf("must be %s or %s", simulateDryRun, simulateLiveNotify)
+	}
+	return nil
+}
+
+// IsBoolFlag は値なしの --simulate を受け付けるためのもの (flag.boolFlag)
+func (f *simulateFlag) IsBoolFlag() bool { return true }
+
+// runSimulation は中断通知を受け取ったことにしてシャットダウンのパイプラインを実行する
+// フック、カウントダウン、通知の流れを本番と同じタイミングで確認できるが、
+// RCONのコマンド、スクリプト、バックアップ、中断イベントの送信はログに出すだけで実行しない
+// 状態ファイルと履歴にも書き込まない
+func runSimulation(config *Config, mode string, actionName string, deadline time.Duration) error {
+	switch actionName {
+	case ActionTerminate, ActionStop, ActionHibernate:
+	default:
+		return fmt.Errorf("unknown action %q (must be terminate, stop or hibernate)", actionName)
+	}
+	if deadline <= 0 {
+		return fmt.Errorf("deadline must be positive: %s", deadline)
+	}
+	action := &InstanceAction{Action: actionName, Time: time.Now().Add(deadline).Truncate(time.Second)}
+
+	provider, err := newInterruptionProvider(config, config.IMDS.newClient())
+	if err != nil {
+		return err
+	}
+	defer closeProvider(provider)
+
+	log.Printf("[simulate] Simulating %s at %s (%s from now) in %s mode.",
+		action.Action, action.Time.Format(time.RFC3339), deadline, mode)
+	run := &shutdownRun{
+		config:   config,
+		instance: provider,
+		server:   newMinecraftServer(config),
+		action:   action,
+		simulate: mode,
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), shutdownDeadline(action))
+	defer cancel()
+
+	p := &Pipeline{Name: "simulate"}
+	p.Steps = run.hookSteps(config.Hooks, p, action.Time.Add(-config.Countdown.stopMargin))
+	p.Run(ctx)
+	log.Printf("[simulate] Finished. Steps:\n%s", formatStepResults(p.Results))
+	return nil
+}
+
+// simulatedStep はシミュレーションで実行する代わりにログに出すステップを返す
+// RCONのコマンドは logCommander が、通知と中断イベントの送信は各ステップが扱うため、そのまま実行する
+func (r *shutdownRun) simulatedStep(h HookConfig, run func(ctx context.Context) error) func(ctx context.Context) error {
+	var what string
+	switch h.Action {
+	case "countdown", "save", "stop", "notify", "publish":
+		return run
+	case "wait":
+		what = "wait for the Minecraft server process to exit"
+	case "backup":
+		if r.config.BackupCommand == "" {
+			return run
+		}
+		what = "run backup command " + r.config.BackupCommand
+	case "script":
+		script := r.config.shutdownScriptFor(r.action.Action)
+		if script == "" {
+			return run
+		}
+		what = "run /bin/sh " + script
+	case "":
+		what = "run " + strings.Join(append([]string{h.Command}, h.Args...), " ")
+	}
+	return func(ctx context.Context) error {
+		log.Printf("[simulate] Would %s.", what)
+		return nil
+	}
+}
+
+// logCommander はRCONのコマンドを送る代わりにログに出す (countdown.Commander を満たす)
+type logCommander struct{}
+
+func (logCommander) Execute(ctx context.Context, command string) (string, error) {
+	log.Printf("[simulate] RCON: %s", command)
+	return "", nil
+}
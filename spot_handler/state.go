@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 状態ファイルのデフォルトの場所 (spot-handler.service の StateDirectory)
+const defaultStateFile = "/var/lib/spot-handler/state.json"
+
+// handlerState はハンドラーが再起動しても引き継ぐ状態
+// 中断の2分間にハンドラーが落ちて再起動しても、シャットダウン処理を最初からやり直したり、
+// 一度も実行しなかったりしないよう、どの中断通知のどのステップまで終えたかを記録する
+type handlerState struct {
+	path string
+	// メンテナンスのフックはタイマーのゴルーチンから記録するため、保存とメンテナンスの状況を排他する
+	mu sync.Mutex
+
+	// 最後に処理した中断通知
+	Interruption *interruptionState `json:"interruption,omitempty"`
+	// 中断時刻を含まない中断通知 (GCP) を最初に検知した時刻
+	// 再起動しても同じ中断通知を同じキーで識別できるよう、処理を始める前に保存する
+	FirstDetectedAt time.Time `json:"firstDetectedAt,omitzero"`
+	// 早期警告を実行したリバランス推奨の noticeTime
+	RebalanceNoticeTime time.Time `json:"rebalanceNoticeTime,omitzero"`
+	// 予定メンテナンスのイベントID → 通知とフックの状況
+	// 再起動してもDiscordへの通知、履歴の記録、フックスクリプトを繰り返さないよう記録する
+	Maintenance map[string]*maintenanceState `json:"maintenance,omitempty"`
+}
+
+// maintenanceState はメンテナンスイベント1件分の処理状況
+type maintenanceState struct {
+	// Discordに通知したときの日時。日時が変更された場合は通知し直す
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter,omitzero"`
+	// フックスクリプトを実行したか
+	HookRan bool `json:"hookRan,omitempty"`
+}
+
+// interruptionState は中断通知1件分の処理状況
+type interruptionState struct {
+	// 中断通知を識別するキー (アクションと中断時刻)
+	Key       string    `json:"key"`
+	Action    string    `json:"action"`
+	Time      time.Time `json:"time"`
+	StartedAt time.Time `json:"startedAt"`
+	// 完了した (ok または skipped の) ステップ
+	Steps map[string]stepState `json:"steps"`
+	// パイプラインを最後まで実行したか
+	Done       bool      `json:"done"`
+	FinishedAt time.Time `json:"finishedAt,omitzero"`
+}
+
+type stepState struct {
+	Status     string    `json:"status"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// interruptionKey は中断通知を識別するキーを返す
+// AWSでは同じ中断通知はポーリングのたびに同じアクションと時刻で返ってくる
+// 時刻が変わりうるクラウドでは、クラウドのIDまたは最初に検知した時刻 (identify) で識別する
+func interruptionKey(action *InstanceAction) string {
+	if action.ID != "" {
+		return action.Action + "#" + action.ID
+	}
+	return action.Action + "@" + action.Time.UTC().Format(time.RFC3339)
+}
+
+// loadState は状態ファイルを読み込む。ファイルがない場合は空の状態を返す
+func loadState(path string) (*handlerState, error) {
+	state := &handlerState{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return &handlerState{path: path}, fmt.Errorf("failed to decode state file %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// save は状態ファイルに書き込む
+// 書き込み中に落ちてもファイルが壊れないよう、一時ファイルに書いてから置き換える
+func (s *handlerState) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+	return nil
+}
+
+// saveOrLog は状態ファイルに書き込み、失敗した場合はログに出す
+// 状態を保存できなくてもシャットダウン処理は続ける
+func (s *handlerState) saveOrLog() {
+	if err := s.save(); err != nil {
+		log.Printf("Failed to save handler state: %v", err)
+	}
+}
+
+// identify は中断時刻を含まない中断通知に、最初に検知した時刻をIDとして付け、中断時刻をそこから決める
+// 最初に検知した時刻を新しく保存した場合はtrueを返す (呼び出し側で状態ファイルに書き込む)
+// 保存した時刻から中断時刻を大きく過ぎている場合は、以前に起動していたときの中断通知とみなす
+func (s *handlerState) identify(action *InstanceAction, now time.Time) bool {
+	if action.Notice == 0 {
+		return false
+	}
+	saved := false
+	if s.FirstDetectedAt.IsZero() || now.After(s.FirstDetectedAt.Add(2*action.Notice)) {
+		s.FirstDetectedAt = action.Time.Add(-action.Notice).UTC()
+		saved = true
+	}
+	action.ID = s.FirstDetectedAt.Format(time.RFC3339Nano)
+	action.Time = s.FirstDetectedAt.Add(action.Notice)
+	return saved
+}
+
+// handled は中断通知の処理を最後まで終えているかを返す
+func (s *handlerState) handled(action *InstanceAction) bool {
+	return s.Interruption != nil && s.Interruption.Key == interruptionKey(action) && s.Interruption.Done
+}
+
+// beginInterruption は中断通知の処理状況を返す
+// 同じ中断通知を処理している途中で再起動した場合は、前回の状況をそのまま返す
+func (s *handlerState) beginInterruption(action *InstanceAction) (rec *interruptionState, resumed bool) {
+	key := interruptionKey(action)
+	if s.Interruption != nil && s.Interruption.Key == key {
+		return s.Interruption, true
+	}
+	s.Interruption = &interruptionState{
+		Key:       key,
+		Action:    action.Action,
+		Time:      action.Time,
+		StartedAt: time.Now(),
+		Steps:     make(map[string]stepState),
+	}
+	return s.Interruption, false
+}
+
+// completedSteps は完了済みのステップ名を返す
+func (r *interruptionState) completedSteps() map[string]bool {
+	done := make(map[string]bool, len(r.Steps))
+	for name := range r.Steps {
+		done[name] = true
+	}
+	return done
+}
+
+// recordStep はステップの結果を記録する。失敗・中止したステップは再起動時にやり直すため記録しない
+func (r *interruptionState) recordStep(result StepResult) {
+	if result.Status == "failed" || result.Status == "aborted" {
+		return
+	}
+	if r.Steps == nil {
+		r.Steps = make(map[string]stepState)
+	}
+	r.Steps[result.Name] = stepState{Status: result.Status, FinishedAt: time.Now()}
+}
+
+// maintenance は保存したメンテナンスイベントの処理状況を返す。記録がない場合はnilを返す
+func (s *handlerState) maintenance(id string) *maintenanceState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.Maintenance[id]; ok {
+		copied := *m
+		return &copied
+	}
+	return nil
+}
+
+// updateMaintenance はメンテナンスイベントの処理状況を更新して保存する
+func (s *handlerState) updateMaintenance(id string, update func(m *maintenanceState)) {
+	s.mu.Lock()
+	if s.Maintenance == nil {
+		s.Maintenance = make(map[string]*maintenanceState)
+	}
+	m, ok := s.Maintenance[id]
+	if !ok {
+		m = &maintenanceState{}
+		s.Maintenance[id] = m
+	}
+	update(m)
+	s.mu.Unlock()
+	s.saveOrLog()
+}
+
+// pruneMaintenance は予定されていないメンテナンスイベントの記録を削除して保存する
+func (s *handlerState) pruneMaintenance(scheduled map[string]bool) {
+	s.mu.Lock()
+	pruned := false
+	for id := range s.Maintenance {
+		if !scheduled[id] {
+			delete(s.Maintenance, id)
+			pruned = true
+		}
+	}
+	s.mu.Unlock()
+	if pruned {
+		s.saveOrLog()
+	}
+}
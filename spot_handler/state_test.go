@@ -0,0 +1,95 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandlerStateHandled(t *testing.T) {
+	at := time.Date(2026, 10, 19, 1, 2, 3, 0, time.UTC)
+	action := &InstanceAction{Action: ActionTerminate, Time: at}
+
+	tests := []struct {
+		name  string
+		state *interruptionState
+		want  bool
+	}{
+		{"no interruption", nil, false},
+		{"same notice, done", &interruptionState{Key: interruptionKey(action), Done: true}, true},
+		{"same notice, in progress", &interruptionState{Key: interruptionKey(action)}, false},
+		{"other time", &interruptionState{Key: interruptionKey(&InstanceAction{Action: ActionTerminate, Time: at.Add(time.Minute)}), Done: true}, false},
+		{"other action", &interruptionState{Key: interruptionKey(&InstanceAction{Action: ActionStop, Time: at}), Done: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &handlerState{Interruption: tt.state}
+			if got := s.handled(action); got != tt.want {
+				t.Errorf("handled = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterruptionKeyIgnoresTimeZone(t *testing.T) {
+	// 同じ中断通知はタイムゾーンに関わらず同じキーになる
+	at := time.Date(2026, 10, 19, 1, 2, 3, 0, time.UTC)
+	tokyo := at.In(time.FixedZone("JST", 9*60*60))
+	a := interruptionKey(&InstanceAction{Action: ActionTerminate, Time: at})
+	b := interruptionKey(&InstanceAction{Action: ActionTerminate, Time: tokyo})
+	if a != b {
+		t.Errorf("keys differ: %q and %q", a, b)
+	}
+}
+
+func TestHandlerStateResumesInterruption(t *testing.T) {
+	action := &InstanceAction{Action: ActionTerminate, Time: time.Now().Add(2 * time.Minute).Truncate(time.Second)}
+	path := filepath.Join(t.TempDir(), "state", "state.json")
+
+	s, err := loadState(path)
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	rec, resumed := s.beginInterruption(action)
+	if resumed {
+		t.Fatal("new interruption was reported as resumed")
+	}
+	rec.recordStep(StepResult{Name: "save", Status: "ok"})
+	rec.recordStep(StepResult{Name: "backup", Status: "skipped"})
+	// 失敗・中止したステップは再起動後にやり直すため記録しない
+	rec.recordStep(StepResult{Name: "stop", Status: "failed"})
+	rec.recordStep(StepResult{Name: "notify", Status: "aborted"})
+	if err := s.save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// 再起動したことにして読み直す
+	s, err = loadState(path)
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if s.handled(action) {
+		t.Fatal("unfinished interruption was reported as handled")
+	}
+	rec, resumed = s.beginInterruption(action)
+	if !resumed {
+		t.Fatal("interruption was not resumed after restart")
+	}
+	done := rec.completedSteps()
+	for _, name := range []string{"save", "backup"} {
+		if !done[name] {
+			t.Errorf("step %s was not recorded as completed", name)
+		}
+	}
+	for _, name := range []string{"stop", "notify"} {
+		if done[name] {
+			t.Errorf("step %s was recorded as completed", name)
+		}
+	}
+
+	// 別の中断通知は最初から処理する
+	other := &InstanceAction{Action: ActionStop, Time: action.Time}
+	if rec, resumed := s.beginInterruption(other); resumed || len(rec.Steps) != 0 {
+		t.Errorf("other interruption: resumed = %v, steps = %v, want a fresh state", resumed, rec.Steps)
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ハンドラーの状態 (/status で返す)
+const (
+	phaseIdle         = "idle"         // 中断通知を待っている
+	phaseWarned       = "warned"       // リバランス推奨を受けて早期警告を実行した
+	phaseInterrupting = "interrupting" // 中断通知を受けてシャットダウン処理を実行している
+	phaseDone         = "done"         // シャットダウン処理を終えた
+)
+
+// IMDSのレイテンシのヒストグラムのバケット (秒)
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// 最後に成功したポーリングからこの回数分の間隔が空いたら /healthz を失敗にする
+const unhealthyAfterPolls = 3
+
+// handlerStats はポーリングの状況とメトリクスを集計する
+// ポーリングのループ、IMDSクライアント、パイプラインから記録し、ステータスサーバーで公開する
+type handlerStats struct {
+	mu sync.Mutex
+
+	startedAt          time.Time
+	pollingInterval    time.Duration
+	provider           string
+	phase              string
+	phaseSince         time.Time
+	lastPoll           time.Time
+	lastSuccessfulPoll time.Time
+	consecutiveErrors  int
+	lastError          string
+	highRisk           bool
+
+	polls         map[string]int // 結果 (ok, error, interruption) → 回数
+	imdsLatency   map[string]*histogram
+	imdsResponses map[[2]string]int // (パス, ステータス) → 回数
+	tokenRefresh  map[string]int    // 結果 (ok, error) → 回数
+	hookRuns      map[hookKey]int
+	hookDuration  map[hookKey]time.Duration // ステータスを除いたキー → 最後の実行時間
+}
+
+// hookKey はフックのメトリクスのラベル
+type hookKey struct {
+	pipeline, hook, status string
+}
+
+type histogram struct {
+	counts []int // latencyBuckets ごとの件数 (累積ではない)
+	count  int
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range latencyBuckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// stats はプロセス全体で共有する集計
+var stats = newHandlerStats()
+
+func newHandlerStats() *handlerStats {
+	now := time.Now()
+	return &handlerStats{
+		startedAt:     now,
+		phase:         phaseIdle,
+		phaseSince:    now,
+		polls:         make(map[string]int),
+		imdsLatency:   make(map[string]*histogram),
+		imdsResponses: make(map[[2]string]int),
+		tokenRefresh:  make(map[string]int),
+		hookRuns:      make(map[hookKey]int),
+		hookDuration:  make(map[hookKey]time.Duration),
+	}
+}
+
+// setConfig はポーリング間隔とプロバイダー名を設定する (設定の再読み込み時にも呼ぶ)
+func (s *handlerStats) setConfig(pollingInterval time.Duration, provider string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pollingInterval = pollingInterval
+	s.provider = provider
+}
+
+// setPhase はハンドラーの状態を変更する
+func (s *handlerStats) setPhase(phase string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.phase != phase {
+		s.phase = phase
+		s.phaseSince = time.Now()
+	}
+}
+
+// setHighRisk は高リスクモードかどうかを設定する
+func (s *handlerStats) setHighRisk(active bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.highRisk = active
+}
+
+// observePoll は中断通知のポーリング1回分の結果を記録する
+func (s *handlerStats) observePoll(interrupted bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.lastPoll = now
+	switch {
+	case err != nil:
+		s.polls["error"]++
+		s.consecutiveErrors++
+		s.lastError = err.Error()
+		return
+	case interrupted:
+		s.polls["interruption"]++
+	default:
+		s.polls["ok"]++
+	}
+	s.lastSuccessfulPoll = now
+	s.consecutiveErrors = 0
+	s.lastError = ""
+}
+
+// observeIMDS はIMDSへのリクエスト1回分のレイテンシを記録する (imds.Hooks.Request)
+func (s *handlerStats) observeIMDS(path string, status int, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.imdsLatency[path]
+	if !ok {
+		h = &histogram{counts: make([]int, len(latencyBuckets))}
+		s.imdsLatency[path] = h
+	}
+	h.observe(d.Seconds())
+	code := "error"
+	if status != 0 {
+		code = strconv.Itoa(status)
+	}
+	s.imdsResponses[[2]string{path, code}]++
+}
+
+// observeTokenRefresh はIMDSv2トークンの取得を記録する (imds.Hooks.TokenRefresh)
+func (s *handlerStats) observeTokenRefresh(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.tokenRefresh["error"]++
+		return
+	}
+	s.tokenRefresh["ok"]++
+}
+
+// observeStep はパイプラインのステップ (フック) の結果を記録する
+func (s *handlerStats) observeStep(pipeline string, result StepResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hookRuns[hookKey{pipeline, result.Name, result.Status}]++
+	// 実行しなかったステップの時間は記録しない
+	if result.Status == "ok" || result.Status == "failed" {
+		s.hookDuration[hookKey{pipeline: pipeline, hook: result.Name}] = result.Duration
+	}
+}
+
+// healthReport は /healthz の応答
+type healthReport struct {
+	Healthy            bool      `json:"healthy"`
+	LastPoll           time.Time `json:"lastPoll,omitzero"`
+	LastSuccessfulPoll time.Time `json:"lastSuccessfulPoll,omitzero"`
+	ConsecutiveErrors  int       `json:"consecutiveErrors"`
+	LastError          string    `json:"lastError,omitempty"`
+}
+
+// health はポーリングが続いているかを返す
+// 最後に成功したポーリング (まだなければ起動時刻) からポーリング間隔の数回分が過ぎていたら異常とみなす
+func (s *handlerStats) health() healthReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	since := s.lastSuccessfulPoll
+	if since.IsZero() {
+		since = s.startedAt
+	}
+	return healthReport{
+		Healthy:            time.Since(since) <= unhealthyAfterPolls*s.pollingInterval,
+		LastPoll:           s.lastPoll,
+		LastSuccessfulPoll: s.lastSuccessfulPoll,
+		ConsecutiveErrors:  s.consecutiveErrors,
+		LastError:          s.lastError,
+	}
+}
+
+// statusReport は /status の応答
+type statusReport struct {
+	State     string    `json:"state"`
+	Since     time.Time `json:"since"`
+	Provider  string    `json:"provider"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+func (s *handlerStats) status() statusReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return statusReport{State: s.phase, Since: s.phaseSince, Provider: s.provider, StartedAt: s.startedAt}
+}
+
+// writeMetrics はPrometheusのテキスト形式でメトリクスを書き出す
+func (s *handlerStats) writeMetrics(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metric(w, "spot_handler_polls_total", "counter", "Interruption polls by result.")
+	for _, result := range sortedKeys(s.polls) {
+		fmt.Fprintf(w, "spot_handler_polls_total{result=%q} %d\n", result, s.polls[result])
+	}
+	metric(w, "spot_handler_consecutive_poll_errors", "gauge", "Consecutive failed interruption polls.")
+	fmt.Fprintf(w, "spot_handler_consecutive_poll_errors %d\n", s.consecutiveErrors)
+	metric(w, "spot_handler_last_successful_poll_timestamp_seconds", "gauge", "Unix time of the last successful interruption poll.")
+	fmt.Fprintf(w, "spot_handler_last_successful_poll_timestamp_seconds %s\n", unixSeconds(s.lastSuccessfulPoll))
+
+	metric(w, "spot_handler_state", "gauge", "Current handler state.")
+	for _, phase := range []string{phaseIdle, phaseWarned, phaseInterrupting, phaseDone} {
+		v := 0
+		if phase == s.phase {
+			v = 1
+		}
+		fmt.Fprintf(w, "spot_handler_state{state=%q} %d\n", phase, v)
+	}
+	metric(w, "spot_handler_high_risk_mode", "gauge", "Whether the handler is saving the world frequently because an interruption is likely.")
+	highRisk := 0
+	if s.highRisk {
+		highRisk = 1
+	}
+	fmt.Fprintf(w, "spot_handler_high_risk_mode %d\n", highRisk)
+
+	metric(w, "spot_handler_imds_request_duration_seconds", "histogram", "IMDS request latency by path.")
+	for _, path := range sortedKeys(s.imdsLatency) {
+		h := s.imdsLatency[path]
+		cumulative := 0
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "spot_handler_imds_request_duration_seconds_bucket{path=%q,le=%q} %d\n", path, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "spot_handler_imds_request_duration_seconds_bucket{path=%q,le=\"+Inf\"} %d\n", path, h.count)
+		fmt.Fprintf(w, "spot_handler_imds_request_duration_seconds_sum{path=%q} %s\n", path, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "spot_handler_imds_request_duration_seconds_count{path=%q} %d\n", path, h.count)
+	}
+	metric(w, "spot_handler_imds_responses_total", "counter", "IMDS responses by path and status code.")
+	for _, key := range sortedPairs(s.imdsResponses) {
+		fmt.Fprintf(w, "spot_handler_imds_responses_total{path=%q,code=%q} %d\n", key[0], key[1], s.imdsResponses[key])
+	}
+	metric(w, "spot_handler_imds_token_refreshes_total", "counter", "IMDSv2 token refreshes by result.")
+	for _, result := range sortedKeys(s.tokenRefresh) {
+		fmt.Fprintf(w, "spot_handler_imds_token_refreshes_total{result=%q} %d\n", result, s.tokenRefresh[result])
+	}
+
+	metric(w, "spot_handler_hook_runs_total", "counter", "Pipeline hook runs by pipeline, hook and status.")
+	for _, key := range sortedHookKeys(s.hookRuns) {
+		fmt.Fprintf(w, "spot_handler_hook_runs_total{pipeline=%q,hook=%q,status=%q} %d\n", key.pipeline, key.hook, key.status, s.hookRuns[key])
+	}
+	metric(w, "spot_handler_hook_duration_seconds", "gauge", "Duration of the last run of each pipeline hook.")
+	for _, key := range sortedHookKeys(s.hookDuration) {
+		fmt.Fprintf(w, "spot_handler_hook_duration_seconds{pipeline=%q,hook=%q} %s\n", key.pipeline, key.hook, strconv.FormatFloat(s.hookDuration[key].Seconds(), 'g', -1, 64))
+	}
+}
+
+func metric(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func unixSeconds(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatFloat(float64(t.UnixMilli())/1000, 'f', 3, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedPairs(m map[[2]string]int) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+func sortedHookKeys[V any](m map[hookKey]V) []hookKey {
+	keys := make([]hookKey, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.pipeline != b.pipeline {
+			return a.pipeline < b.pipeline
+		}
+		if a.hook != b.hook {
+			return a.hook < b.hook
+		}
+		return a.status < b.status
+	})
+	return keys
+}
+
+// startStatusServer は /healthz, /status, /metrics を返すHTTPサーバーをバックグラウンドで起動する
+func startStatusServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		report := stats.health()
+		code := http.StatusOK
+		if !report.Healthy {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, report)
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, stats.status())
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		stats.writeMetrics(w)
+	})
+
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		log.Printf("Status server listening on %s", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			// ステータスサーバーが使えなくても中断の監視は続ける
+			log.Printf("Status server stopped: %v", err)
+		}
+	}()
+	return server
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"common/sdnotify"
+)
+
+// 時間のかかる処理 (シャットダウン処理など) が予定の時間を過ぎても、この時間まではウォッチドッグに送り続ける
+const watchdogGrace = 30 * time.Second
+
+// setPhase はハンドラーの状態を変更し、systemctl status に表示する説明を送る
+func setPhase(phase, status string) {
+	stats.setPhase(phase)
+	notifyStatus(status)
+}
+
+// notifyStatus は systemctl status に表示する状態を送る
+func notifyStatus(status string) {
+	if err := sdnotify.SetStatus(status); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+}
+
+// watchdog は systemd のウォッチドッグ (WatchdogSec) にポーリングのループが動いていることを知らせる
+// ウォッチドッグが無効な場合は nil で、nil のメソッドは何もしない
+type watchdog struct {
+	interval time.Duration
+}
+
+func newWatchdog() *watchdog {
+	timeout, ok := sdnotify.WatchdogInterval()
+	if !ok {
+		return nil
+	}
+	// 期限の半分の間隔で送る
+	return &watchdog{interval: timeout / 2}
+}
+
+// tick はループでウォッチドッグに送るタイミングを返す
+func (w *watchdog) tick() (<-chan time.Time, func()) {
+	if w == nil {
+		return nil, func() {}
+	}
+	t := time.NewTicker(w.interval)
+	return t.C, t.Stop
+}
+
+func (w *watchdog) ping() {
+	if w == nil {
+		return
+	}
+	if err := sdnotify.Watchdog(); err != nil {
+		log.Printf("Failed to notify systemd watchdog: %v", err)
+	}
+}
+
+// keepAlive はループを止めて時間のかかる処理をしている間、until まではウォッチドッグに送り続ける
+// until を過ぎても処理が終わらない場合は送るのをやめ、systemd にハンドラーを再起動させる
+// 返り値の関数で送るのをやめる
+func (w *watchdog) keepAlive(until time.Time) func() {
+	if w == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(w.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-t.C:
+				if now.After(until) {
+					log.Printf("Still busy after %s. Stopping watchdog pings.", until.Format(time.RFC3339))
+					return
+				}
+				w.ping()
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"time"
+)
+
+// runValidate は validate サブコマンド
+// 設定ファイルを読み込み、スクリプトやURL、時間の設定を実際の中断の前に確認する
+//
+//	spot-handler validate [-config path]
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := fs.String("config", defaultConfigPath, "Path to the configuration file")
+	fs.Parse(args)
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *configPath, err)
+	}
+
+	problems := config.check()
+	errors := 0
+	for _, p := range problems {
+		if p.warning {
+			fmt.Printf("warning: %s\n", p.message)
+		} else {
+			fmt.Printf("error: %s\n", p.message)
+			errors++
+		}
+	}
+	if errors > 0 {
+		return fmt.Errorf("%s: %d errors, %d warnings", *configPath, errors, len(problems)-errors)
+	}
+	fmt.Printf("%s: OK (%d warnings)\n", *configPath, len(problems))
+	return nil
+}
+
+// configProblem は validate が見つけた設定の問題
+type configProblem struct {
+	warning bool
+	message string
+}
+
+// configChecker は設定の問題を集める
+type configChecker struct {
+	problems []configProblem
+}
+
+func (c *configChecker) errorf(format string, args ...any) {
+	c.problems = append(c.problems, configProblem{message: fmt.Sprintf(format, args...)})
+}
+
+func (c *configChecker) warnf(format string, args ...any) {
+	c.problems = append(c.problems, configProblem{warning: true, message: fmt.Sprintf(format, args...)})
+}
+
+// duration は時間が [min, max] の範囲にあるかを確認する
+func (c *configChecker) duration(name string, d, min, max time.Duration) {
+	if d < min || d > max {
+		c.errorf("%s must be between %s and %s: %s", name, min, max, d)
+	}
+}
+
+// url は http または https のURLかを確認する。空の場合は何もしない
+func (c *configChecker) url(name, value string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		c.errorf("%s is not a valid URL: %v", name, err)
+		return
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.errorf("%s must be an http or https URL: %q", name, value)
+	}
+}
+
+// script は /bin/sh で実行するスクリプトが読めて、実行権限があるかを確認する。空の場合は何もしない
+// 実行権限がないのは配置し忘れや書き換えの途中であることが多いため、エラーとして扱う
+func (c *configChecker) script(name, path string) {
+	if path == "" {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		c.errorf("%s: %v", name, err)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		c.errorf("%s: %s is not a regular file", name, path)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		c.errorf("%s: %v", name, err)
+		return
+	}
+	f.Close()
+	if info.Mode().Perm()&0o111 == 0 {
+		c.errorf("%s: %s is not executable", name, path)
+	}
+}
+
+// command は直接実行するコマンドが見つかり、実行できるかを確認する。空の場合は何もしない
+func (c *configChecker) command(name, command string) {
+	if command == "" {
+		return
+	}
+	if _, err := exec.LookPath(command); err != nil {
+		c.errorf("%s: %v", name, err)
+	}
+}
+
+// check は設定の問題を返す
+// loadConfig の検証 (書式や必須の項目) に加えて、ファイルの存在や時間の範囲など実行時に初めてわかる問題を調べる
+func (c *Config) check() []configProblem {
+	k := &configChecker{}
+
+	// 中断通知は2分前 (GCPは30秒前) に届くため、ポーリングやステップがそれより長いと間に合わない
+	k.duration("pollingInterval", c.pollingInterval, time.Second, time.Minute)
+	for _, step := range slices.Sorted(maps.Keys(c.stepTimeouts)) {
+		k.duration("stepTimeouts."+step, c.stepTimeouts[step], time.Second, 2*time.Minute)
+	}
+	for _, d := range c.Countdown.points {
+		k.duration("countdown.points", d, time.Second, 2*time.Minute)
+	}
+	k.duration("countdown.stopMargin", c.Countdown.stopMargin, 0, 2*time.Minute)
+	k.duration("pollFailures.maxInterval", c.PollFailures.maxInterval, c.pollingInterval, 5*time.Minute)
+	if c.Maintenance.Enabled {
+		k.duration("maintenance.pollingInterval", c.Maintenance.pollingInterval, time.Minute, time.Hour)
+		k.duration("maintenance.hookLeadTime", c.Maintenance.hookLeadTime, 0, 24*time.Hour)
+		for _, d := range c.Maintenance.announceBefore {
+			k.duration("maintenance.announceBefore", d, time.Minute, 7*24*time.Hour)
+		}
+	}
+	if c.HighRisk.Enabled {
+		k.duration("highRisk.saveInterval", c.HighRisk.saveInterval, 10*time.Second, 30*time.Minute)
+		k.duration("highRisk.quietPeriod", c.HighRisk.quietPeriod, time.Minute, 7*24*time.Hour)
+		k.duration("highRisk.zoneWindow", c.HighRisk.zoneWindow, time.Hour, 90*24*time.Hour)
+	}
+
+	if c.MetadataURL != "" {
+		k.warnf("metadataUrl is deprecated. Use imds.baseUrl instead")
+	}
+	if c.RebalanceURL != "" {
+		k.warnf("rebalanceUrl is deprecated. Use imds.baseUrl and checkRebalance: true instead")
+	}
+	switch c.Provider {
+	case providerAWS:
+		k.url("imds.baseUrl", c.IMDS.BaseURL)
+	case providerGCP:
+		k.url("gcp.baseUrl", c.GCP.BaseURL)
+	case providerAzure:
+		k.url("azure.baseUrl", c.Azure.BaseURL)
+	}
+	k.url("discordWebhookUrl", c.DiscordWebhookURL)
+	k.url("pollFailures.webhookUrl", c.PollFailures.WebhookURL)
+	k.url("failover.queueUrl", c.Failover.QueueURL)
+	if c.DiscordWebhookURL == "" {
+		k.warnf("discordWebhookUrl is not set. Interruptions will not be notified")
+	}
+
+	// スクリプトがないことは実際に中断されるまでわからないため、ここで知らせる
+	if c.ShutdownScript == "" && len(c.ActionScripts) == 0 {
+		k.warnf("shutdownScript is not set. The script hook will be skipped on interruption")
+	}
+	k.script("shutdownScript", c.ShutdownScript)
+	for _, action := range slices.Sorted(maps.Keys(c.ActionScripts)) {
+		k.script("actionScripts."+action, c.ActionScripts[action])
+	}
+	k.script("earlyWarningScript", c.EarlyWarningScript)
+	if c.Maintenance.Enabled {
+		k.script("maintenance.hookScript", c.Maintenance.HookScript)
+	}
+	k.command("backupCommand", c.BackupCommand)
+	c.checkHooks(k, c.Hooks, "hooks")
+	if c.Failover.QueueURL != "" {
+		// バックアップより前 (または並行して) 送ると、置き換えのインスタンスは前回のバックアップから復元する
+		publish := slices.IndexFunc(c.Hooks, func(h HookConfig) bool { return hookRuns(h, "publish") })
+		backup := slices.IndexFunc(c.Hooks, func(h HookConfig) bool { return hookRuns(h, "backup") })
+		if publish >= 0 && backup >= publish {
+			k.warnf("hooks[%d]: publish runs before backup finishes, so the replacement instance will restore the previous backup. Move it after backup", publish)
+		}
+	}
+
+	if c.RCON.PasswordFile == "" {
+		k.errorf("rcon.passwordFile is not set")
+	} else if _, err := os.ReadFile(c.RCON.PasswordFile); err != nil {
+		k.errorf("rcon.passwordFile: %v", err)
+	}
+	return k.problems
+}
+
+// checkHooks はフックのコマンドとタイムアウトを確認する
+func (c *Config) checkHooks(k *configChecker, hooks []HookConfig, path string) {
+	for i, h := range hooks {
+		name := fmt.Sprintf("%s[%d]", path, i)
+		if len(h.Parallel) > 0 {
+			c.checkHooks(k, h.Parallel, name+".parallel")
+			continue
+		}
+		k.command(name+".command", h.Command)
+		if h.timeout > 0 {
+			k.duration(name+".timeout", h.timeout, time.Second, 2*time.Minute)
+		}
+	}
+}
+
+// hookRuns はフック (並列グループの場合はその中のどれか) が組み込みの処理 action を実行するかを返す
+func hookRuns(h HookConfig, action string) bool {
+	return h.Action == action || slices.ContainsFunc(h.Parallel, func(p HookConfig) bool { return p.Action == action })
+}